@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+)
+
+// ── linked accounts ───────────────────────────────────────────────────────
+//
+// An account groups identities that belong to the same person, e.g. a
+// Telegram id, a Google sub and a wallet key. Accounts are managed by the
+// admin; whatever applies to one identity of an account applies to all.
+
+// linkedIdentities returns identity together with every identity sharing
+// an account with it. Caller must hold storeMu.
+func linkedIdentities(identity string) []string {
+	out := []string{identity}
+	for _, ids := range store.Accounts {
+		if slices.Contains(ids, identity) {
+			out = mergeNames(out, ids)
+		}
+	}
+	return out
+}
+
+// GET /admin/accounts/{account}
+func handleAdminGetAccount(w http.ResponseWriter, r *http.Request) {
+	storeMu.Lock()
+	ids, ok := store.Accounts[r.PathValue("account")]
+	storeMu.Unlock()
+	if !ok {
+		jsonErr(w, "not found", http.StatusNotFound)
+		return
+	}
+	jsonOK(w, map[string]any{"identities": ids})
+}
+
+// PUT /admin/accounts/{account}
+func handleAdminPutAccount(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Identities []string `json:"identities"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || len(body.Identities) == 0 {
+		jsonErr(w, "identities required", http.StatusBadRequest)
+		return
+	}
+	storeMu.Lock()
+	store.Accounts[r.PathValue("account")] = body.Identities
+	err := saveStore()
+	storeMu.Unlock()
+	if err != nil {
+		jsonErr(w, "save failed", http.StatusInternalServerError)
+		return
+	}
+	jsonOK(w, map[string]any{"identities": body.Identities})
+}
+
+// DELETE /admin/accounts/{account}
+func handleAdminDeleteAccount(w http.ResponseWriter, r *http.Request) {
+	storeMu.Lock()
+	delete(store.Accounts, r.PathValue("account"))
+	err := saveStore()
+	storeMu.Unlock()
+	if err != nil {
+		jsonErr(w, "save failed", http.StatusInternalServerError)
+		return
+	}
+	jsonOK(w, map[string]any{"ok": true})
+}
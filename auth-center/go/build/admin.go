@@ -0,0 +1,29 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// ── admin api ─────────────────────────────────────────────────────────────
+//
+// Every /admin/ endpoint requires "Authorization: Bearer <ADMIN_TOKEN>".
+// Without ADMIN_TOKEN the admin API is disabled.
+
+var adminToken string
+
+func requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if adminToken == "" {
+			http.NotFound(w, r)
+			return
+		}
+		tok := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(tok), []byte(adminToken)) != 1 {
+			jsonErr(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
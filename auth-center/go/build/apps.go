@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"text/template"
+)
+
+// ── app registry ──────────────────────────────────────────────────────────
+//
+// APPS_FILE is an optional JSON file describing the applications that use
+// auth-center:
+//
+//	{
+//	  "apps": {
+//	    "shop": {
+//	      "name":      "Shop",
+//	      "token":     "<secret for /exchange>",
+//	      "redirects": ["https://shop.example.com/callback"],
+//	      "rule":      "method == 'google' && user.email.endsWith('@ourco.com')",
+//	      "claims":    { "staff": "method == 'google'" },
+//	      "invite_only": true,
+//	      "scopes":    ["phone"],
+//	      "chats":     ["-1001234567890", "@ourchannel"],
+//	      "telegram_2fa": true,
+//	      "templates":   { "shipped": "Order {{.order}} has shipped" },
+//	      "notify_rate": 20,
+//	      "bot":       "shop",
+//	      "locale":    "ru"
+//	    }
+//	  },
+//	  "bots": { ... }
+//	}
+//
+// The app of a login is resolved from the redirect URL, the app calling
+// /exchange from its token. Plain APP_TOKENS keep working without a file.
+// The "bots" section is described in bots.go.
+
+type App struct {
+	ID        string            `json:"-"`
+	Name      string            `json:"name"`
+	Token     string            `json:"token"`
+	Redirects []string          `json:"redirects"`
+	Rule      string            `json:"rule"`
+	Claims    map[string]string `json:"claims"`
+
+	InviteOnly bool     `json:"invite_only"` // first login needs an invite code
+	Scopes     []string `json:"scopes"`      // extra user data: "phone"
+	Chats      []string `json:"chats"`       // telegram chats the user must be in
+
+	SecondFactor bool `json:"telegram_2fa"` // google/solana also approved in telegram
+
+	Templates  map[string]string `json:"templates"`   // POST /notify messages
+	NotifyRate int               `json:"notify_rate"` // per minute, default 20
+
+	Bot    string `json:"bot"`    // telegram bot from "bots", default bot if empty
+	Locale string `json:"locale"` // page and bot language when the user's is unknown
+
+	rules     *appRules
+	templates map[string]*template.Template
+}
+
+var apps = make(map[string]*App)
+
+func loadApps(path string) error {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var file struct {
+		Apps map[string]*App `json:"apps"`
+		Bots map[string]*Bot `json:"bots"`
+	}
+	if err := json.Unmarshal(src, &file); err != nil {
+		return err
+	}
+	for id, b := range file.Bots {
+		if id == "" || b == nil || b.Token == "" || b.Username == "" {
+			return fmt.Errorf("bot %q: id, token and username are required", id)
+		}
+		// "default" names the BOT_TOKEN bot in logs, /status and update dedup
+		if id == "default" {
+			return fmt.Errorf("bot %q: the id is reserved", id)
+		}
+		// without it anyone could post forged updates to /webhook/{bot}
+		if b.Secret == "" && botMode != "polling" {
+			return fmt.Errorf("bot %q: webhook_secret is required", id)
+		}
+		b.ID = id
+		bots[id] = b
+	}
+	for id, app := range file.Apps {
+		app.ID = id
+		if app.Bot != "" && bots[app.Bot] == nil {
+			return fmt.Errorf("app %q: unknown bot %q", id, app.Bot)
+		}
+		if app.Locale != "" && matchLang(app.Locale) == "" {
+			return fmt.Errorf("app %q: unsupported locale %q", id, app.Locale)
+		}
+		if app.rules, err = compileRules(app); err != nil {
+			return fmt.Errorf("app %q: %w", id, err)
+		}
+		if app.templates, err = compileTemplates(app); err != nil {
+			return fmt.Errorf("app %q: %w", id, err)
+		}
+		apps[id] = app
+	}
+	return nil
+}
+
+var errPhoneRequired = errors.New("phone number required: log in with the QR code")
+
+func (app *App) wants(scope string) bool {
+	return app != nil && slices.Contains(app.Scopes, scope)
+}
+
+func appByToken(tok string) *App {
+	if tok == "" {
+		return nil
+	}
+	for _, app := range apps {
+		if app.Token == tok {
+			return app
+		}
+	}
+	return nil
+}
+
+// appForRedirect finds the app whose registered redirect prefix covers
+// redirect. Scheme and host must match exactly, the path by prefix.
+func appForRedirect(redirect string) *App {
+	target, err := url.Parse(redirect)
+	if err != nil || redirect == "" {
+		return nil
+	}
+	for _, app := range apps {
+		for _, prefix := range app.Redirects {
+			p, err := url.Parse(prefix)
+			if err != nil {
+				continue
+			}
+			if p.Scheme == target.Scheme && p.Host == target.Host &&
+				strings.HasPrefix(target.Path, p.Path) {
+				return app
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ── backchannel authentication ────────────────────────────────────────────
+//
+// CIBA-style confirmation without a browser: a registered app names a
+// Telegram user it already knows (POST /bc/authorize), the bot asks that
+// user to approve or deny, and the app polls POST /bc/token or receives
+// the result at its callback_url. An approval yields the usual one-time
+// code for /exchange, bound to the requesting app. Only users who have
+// logged in to the app can be asked, at most bcRate times a minute per app.
+
+const (
+	bcTTL      = 5 * time.Minute
+	bcInterval = 3  // seconds between polls suggested to the app
+	bcRate     = 10 // requests per minute per app
+)
+
+type bcRequest struct {
+	App        *App
+	TelegramID int64
+	Message    string
+	Callback   string
+	PublicURL  string
+	IP         string
+	UserAgent  string
+	Status     string // pending, claimed (reported as pending), approved, denied
+	Code       string
+	Error      string
+	CreatedAt  time.Time
+}
+
+var (
+	bcRequests   = make(map[string]*bcRequest)
+	bcRequestsMu sync.Mutex
+	bcBuckets    = make(map[string]*rateBucket)
+
+	bcClient         = &http.Client{Timeout: 5 * time.Second, Transport: telegramTransport}
+	bcCallbackClient = &http.Client{Timeout: 5 * time.Second}
+)
+
+func cleanBCRequests() {
+	bcRequestsMu.Lock()
+	defer bcRequestsMu.Unlock()
+	for id, req := range bcRequests {
+		if time.Since(req.CreatedAt) > bcTTL {
+			delete(bcRequests, id)
+		}
+	}
+}
+
+// POST /bc/authorize
+func handleBCAuthorize(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		AppToken       string `json:"app_token"`
+		TelegramID     int64  `json:"telegram_id"`
+		BindingMessage string `json:"binding_message"`
+		CallbackURL    string `json:"callback_url"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		jsonErr(w, "no data", http.StatusBadRequest)
+		return
+	}
+	app := appByToken(body.AppToken)
+	if app == nil {
+		jsonErr(w, "unauthorized", http.StatusForbidden)
+		return
+	}
+	if body.TelegramID == 0 {
+		jsonErr(w, "missing telegram_id", http.StatusBadRequest)
+		return
+	}
+	if body.CallbackURL != "" && appForRedirect(body.CallbackURL) != app {
+		jsonErr(w, "callback_url not registered", http.StatusBadRequest)
+		return
+	}
+	if len(body.BindingMessage) > 200 {
+		jsonErr(w, "binding_message too long", http.StatusBadRequest)
+		return
+	}
+	identity := identityKey("telegram", body.TelegramID)
+	if !registeredWith(app, identity) {
+		jsonErr(w, "user never logged in to this app", http.StatusNotFound)
+		return
+	}
+	if isSuspended(identity) {
+		jsonErr(w, "account suspended", http.StatusForbidden)
+		return
+	}
+	if !takeRate(bcBuckets, app.ID, bcRate) {
+		w.Header().Set("Retry-After", "60")
+		jsonErr(w, "rate limit exceeded", http.StatusTooManyRequests)
+		return
+	}
+
+	cleanBCRequests()
+	id := randToken(16)
+	req := &bcRequest{
+		App:        app,
+		TelegramID: body.TelegramID,
+		Message:    body.BindingMessage,
+		Callback:   body.CallbackURL,
+		PublicURL:  publicURL(r),
+		IP:         clientIP(r),
+		UserAgent:  r.UserAgent(),
+		Status:     "pending",
+		CreatedAt:  time.Now(),
+	}
+
+	lang := appLang(app)
+	text := botMsg(lang, "bc_confirm", appName(app))
+	if req.Message != "" {
+		text += "\n\n" + req.Message
+	}
+	text += "\n\n" + botMsg(lang, "bc_not_you")
+	// synchronous: a user who never started the bot cannot be reached
+	err := tgRequest(appBot(app), bcClient, "sendMessage", map[string]any{
+		"chat_id": req.TelegramID,
+		"text":    text,
+		"reply_markup": map[string]any{
+			"inline_keyboard": [][]map[string]string{{
+				{"text": botMsg(lang, "approve"), "callback_data": "bc_ok:" + id},
+				{"text": botMsg(lang, "deny"), "callback_data": "bc_no:" + id},
+			}},
+		},
+	}, nil)
+	if err != nil {
+		log.Printf("backchannel: %v", err)
+		jsonErr(w, "user unreachable", http.StatusBadGateway)
+		return
+	}
+
+	bcRequestsMu.Lock()
+	bcRequests[id] = req
+	bcRequestsMu.Unlock()
+	jsonOK(w, map[string]any{
+		"ok":          true,
+		"auth_req_id": id,
+		"expires_in":  int(bcTTL.Seconds()),
+		"interval":    bcInterval,
+	})
+}
+
+// POST /bc/token
+func handleBCToken(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		AppToken  string `json:"app_token"`
+		AuthReqID string `json:"auth_req_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		jsonErr(w, "no data", http.StatusBadRequest)
+		return
+	}
+	app := appByToken(body.AppToken)
+	if app == nil {
+		jsonErr(w, "unauthorized", http.StatusForbidden)
+		return
+	}
+
+	cleanBCRequests()
+	bcRequestsMu.Lock()
+	defer bcRequestsMu.Unlock()
+	req, ok := bcRequests[body.AuthReqID]
+	if !ok || req.App != app {
+		jsonErr(w, "expired", http.StatusNotFound)
+		return
+	}
+	jsonOK(w, bcResult(body.AuthReqID, req))
+	if req.Status == "approved" || req.Status == "denied" {
+		delete(bcRequests, body.AuthReqID)
+	}
+}
+
+// bcResult is the poll response and the callback body.
+func bcResult(id string, req *bcRequest) map[string]any {
+	status := req.Status
+	if status == "claimed" {
+		status = "pending"
+	}
+	res := map[string]any{"ok": true, "auth_req_id": id, "status": status}
+	if req.Code != "" {
+		res["code"] = req.Code
+	}
+	if req.Error != "" {
+		res["error"] = req.Error
+	}
+	return res
+}
+
+// handleBCCallback serves the Approve/Deny buttons.
+func handleBCCallback(b *Bot, cq *tgCallbackQuery, action, id string) {
+	lang := userLang(cq.From, nil)
+
+	bcRequestsMu.Lock()
+	req, ok := bcRequests[id]
+	if !ok || req.Status != "pending" || req.TelegramID != cq.From.ID ||
+		time.Since(req.CreatedAt) > bcTTL {
+		bcRequestsMu.Unlock()
+		answerAndEdit(b, cq, botMsg(lang, "bc_expired"))
+		return
+	}
+	// claim it before issuing so a second tap cannot approve twice
+	req.Status = "claimed"
+	bcRequestsMu.Unlock()
+
+	var code string
+	err := errDenied
+	text := botMsg(lang, "bc_denied")
+	if action == "bc_ok" {
+		code, err = issueCode(&login{
+			Method:    "telegram",
+			User:      cq.From.userMap(b, req.PublicURL),
+			App:       req.App,
+			IP:        req.IP,
+			UserAgent: req.UserAgent,
+		})
+		text = botMsg(lang, "bc_confirmed")
+		if err != nil {
+			text = botMsg(lang, "bc_denied_reason", err.Error())
+		}
+	}
+
+	bcRequestsMu.Lock()
+	if err != nil {
+		req.Status = "denied"
+		req.Error = err.Error()
+		if action == "bc_no" {
+			req.Error = "denied in telegram"
+		}
+	} else {
+		req.Status = "approved"
+		req.Code = code
+	}
+	res := bcResult(id, req)
+	callback := req.Callback
+	bcRequestsMu.Unlock()
+
+	answerAndEdit(b, cq, text)
+	if callback != "" {
+		go postBCCallback(callback, res)
+	}
+}
+
+// postBCCallback notifies the app; the result stays pollable until expiry.
+func postBCCallback(callback string, res map[string]any) {
+	body, _ := json.Marshal(res)
+	resp, err := bcCallbackClient.Post(callback, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Printf("backchannel callback: %v", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.Printf("backchannel callback: %s", strings.TrimSpace(resp.Status))
+	}
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"math/rand/v2"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ── telegram bot ──────────────────────────────────────────────────────────
+//
+// QR login in two steps: "/start <token>" marks the session scanned and
+// asks the user to confirm with app name, IP and browser. The login page
+// then shows a two-digit number and the bot offers three; only picking the
+// matching one authenticates the session. A forwarded QR link therefore
+// cannot log anybody in without them seeing the page they approve.
+// An app with the "phone" scope additionally gets the user's own number,
+// shared through a request_contact keyboard.
+
+type tgUser struct {
+	ID        int64  `json:"id"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Username  string `json:"username"`
+
+	LanguageCode string `json:"language_code"`
+	IsPremium    bool   `json:"is_premium"`
+}
+
+type tgChat struct {
+	ID   int64  `json:"id"`
+	Type string `json:"type"` // private, group, supergroup, channel
+}
+
+type tgContact struct {
+	PhoneNumber string `json:"phone_number"`
+	UserID      int64  `json:"user_id"`
+}
+
+type tgMessage struct {
+	MessageID int64      `json:"message_id"`
+	Text      string     `json:"text"`
+	From      tgUser     `json:"from"`
+	Chat      tgChat     `json:"chat"`
+	Contact   *tgContact `json:"contact"`
+}
+
+type tgCallbackQuery struct {
+	ID      string     `json:"id"`
+	From    tgUser     `json:"from"`
+	Data    string     `json:"data"`
+	Message *tgMessage `json:"message"`
+}
+
+type tgChatMemberUpdated struct {
+	Chat          tgChat `json:"chat"`
+	From          tgUser `json:"from"`
+	NewChatMember struct {
+		Status string `json:"status"`
+	} `json:"new_chat_member"`
+}
+
+type tgUpdate struct {
+	UpdateID      int64                `json:"update_id"`
+	Message       *tgMessage           `json:"message"`
+	EditedMessage *tgMessage           `json:"edited_message"`
+	CallbackQuery *tgCallbackQuery     `json:"callback_query"`
+	MyChatMember  *tgChatMemberUpdated `json:"my_chat_member"`
+}
+
+// userMap is the normalized user returned by /exchange. b is the bot
+// that vouched for the user and base the public URL of auth-center, both
+// used for the picture proxy.
+func (u tgUser) userMap(b *Bot, base string) map[string]any {
+	user := map[string]any{
+		"id":         u.ID,
+		"first_name": u.FirstName,
+		"last_name":  u.LastName,
+		"username":   u.Username,
+	}
+	if u.LanguageCode != "" {
+		user["language_code"] = u.LanguageCode
+	}
+	if u.IsPremium {
+		user["is_premium"] = true
+	}
+	if b != nil && base != "" {
+		user["picture"] = avatarURL(b, base, u.ID)
+	}
+	return user
+}
+
+// appName is how the bot refers to app.
+func appName(app *App) string {
+	if app.Name != "" {
+		return app.Name
+	}
+	return app.ID
+}
+
+// appLabel names the app behind redirect for the confirmation message.
+func appLabel(redirect string) string {
+	if app := appForRedirect(redirect); app != nil {
+		return appName(app)
+	}
+	if u, err := url.Parse(redirect); err == nil && u.Host != "" {
+		return u.Host
+	}
+	return "auth-center"
+}
+
+// handleStart serves "/start <tok>" from a QR deep link.
+func handleStart(b *Bot, msg *tgMessage, tok string) {
+	from := msg.From
+	suspended := isSuspended(identityKey("telegram", from.ID))
+
+	sessionsMu.Lock()
+	sess, ok := sessions[tok]
+	// the QR code names the bot; another bot never sees its token
+	if !ok || sess.Bot != b {
+		sessionsMu.Unlock()
+		return
+	}
+	lang := userLang(from, appForRedirect(sess.Redirect))
+	if suspended {
+		sess.Status = "denied"
+		sessionsMu.Unlock()
+		sendTG(b, from.ID, botMsg(lang, "suspended"))
+		return
+	}
+	if time.Since(sess.CreatedAt) > sessionTTL {
+		delete(sessions, tok)
+		sessionsMu.Unlock()
+		sendTG(b, from.ID, botMsg(lang, "qr_expired"))
+		return
+	}
+	rescan := sess.Status == "scanned" && sess.Scanner.ID == from.ID
+	if sess.Status != "pending" && !rescan {
+		sessionsMu.Unlock()
+		sendTG(b, from.ID, botMsg(lang, "qr_expired"))
+		return
+	}
+	if !rescan {
+		sess.Number = 10 + rand.IntN(90)
+	}
+	sess.Status = "scanned"
+	sess.Scanner = &from
+	text := botMsg(lang, "confirm_login", appLabel(sess.Redirect), sess.IP, sess.UserAgent)
+	choices := numberChoices(sess.Number)
+	sessionsMu.Unlock()
+
+	var row []map[string]string
+	for _, n := range choices {
+		row = append(row, map[string]string{
+			"text":          strconv.Itoa(n),
+			"callback_data": "pick:" + strconv.Itoa(n) + ":" + tok,
+		})
+	}
+	tgCall(b, "sendMessage", map[string]any{
+		"chat_id": from.ID,
+		"text":    text,
+		"reply_markup": map[string]any{
+			"inline_keyboard": [][]map[string]string{
+				row,
+				{{"text": botMsg(lang, "deny"), "callback_data": "deny:" + tok}},
+			},
+		},
+	})
+}
+
+// numberChoices returns n and two different two-digit decoys, shuffled.
+func numberChoices(n int) []int {
+	out := []int{n}
+	for len(out) < 3 {
+		d := 10 + rand.IntN(90)
+		if !slices.Contains(out, d) {
+			out = append(out, d)
+		}
+	}
+	rand.Shuffle(len(out), func(i, j int) { out[i], out[j] = out[j], out[i] })
+	return out
+}
+
+func handleCallback(b *Bot, cq *tgCallbackQuery) {
+	action, tok, _ := strings.Cut(cq.Data, ":")
+	picked := 0
+	switch action {
+	case "revoke", "logout_all":
+		handleRevokeCallback(b, cq, action, tok)
+		return
+	case "notme":
+		handleNotMeCallback(b, cq, tok)
+		return
+	case "bc_ok", "bc_no":
+		handleBCCallback(b, cq, action, tok)
+		return
+	case "mfa_ok", "mfa_no":
+		handleApprovalCallback(b, cq, action, tok)
+		return
+	case "optout", "optin":
+		handleOptCallback(b, cq, action, tok)
+		return
+	case "deny":
+	case "pick":
+		var n string
+		n, tok, _ = strings.Cut(tok, ":")
+		picked, _ = strconv.Atoi(n)
+	default:
+		return
+	}
+	lang := userLang(cq.From, nil)
+
+	sessionsMu.Lock()
+	sess, ok := sessions[tok]
+	if !ok || sess.Bot != b || sess.Status != "scanned" || sess.NeedPhone || sess.Scanner.ID != cq.From.ID ||
+		time.Since(sess.CreatedAt) > sessionTTL {
+		sessionsMu.Unlock()
+		answerAndEdit(b, cq, botMsg(lang, "expired"))
+		return
+	}
+
+	if action == "deny" {
+		sess.Status = "denied"
+		sess.Error = "denied in telegram"
+		sessionsMu.Unlock()
+		answerAndEdit(b, cq, botMsg(lang, "denied"))
+		return
+	}
+	// one attempt only: a wrong pick kills the session
+	if picked != sess.Number {
+		sess.Status = "denied"
+		sess.Error = "wrong number picked in telegram"
+		sessionsMu.Unlock()
+		answerAndEdit(b, cq, botMsg(lang, "wrong_number"))
+		return
+	}
+
+	if appForRedirect(sess.Redirect).wants("phone") {
+		sess.NeedPhone = true
+		sessionsMu.Unlock()
+		answerAndEdit(b, cq, botMsg(lang, "number_ok"))
+		tgCall(b, "sendMessage", map[string]any{
+			"chat_id": cq.From.ID,
+			"text":    botMsg(lang, "ask_phone"),
+			"reply_markup": map[string]any{
+				"keyboard": [][]map[string]any{
+					{{"text": botMsg(lang, "share_phone"), "request_contact": true}},
+				},
+				"one_time_keyboard": true,
+				"resize_keyboard":   true,
+			},
+		})
+		return
+	}
+
+	l := approveSession(sess, sess.Scanner.userMap(b, sess.PublicURL))
+	sessionsMu.Unlock()
+	answerAndEdit(b, cq, completeTelegramLogin(sess, l, lang))
+}
+
+// handleContact finishes a login waiting for the sender's phone number.
+func handleContact(b *Bot, msg *tgMessage) {
+	from := msg.From
+	lang := userLang(from, nil)
+	removeKeyboard := func(text string) {
+		tgCall(b, "sendMessage", map[string]any{
+			"chat_id":      from.ID,
+			"text":         text,
+			"reply_markup": map[string]any{"remove_keyboard": true},
+		})
+	}
+	// only the sender's own contact proves the number is theirs
+	if msg.Contact.UserID != from.ID {
+		sendTG(b, from.ID, botMsg(lang, "own_contact"))
+		return
+	}
+
+	sessionsMu.Lock()
+	var sess *Session
+	for _, s := range sessions {
+		if s.Bot == b && s.NeedPhone && s.Status == "scanned" && s.Scanner.ID == from.ID &&
+			time.Since(s.CreatedAt) <= sessionTTL {
+			sess = s
+			break
+		}
+	}
+	if sess == nil {
+		sessionsMu.Unlock()
+		removeKeyboard(botMsg(lang, "expired"))
+		return
+	}
+	sess.NeedPhone = false
+	phone := msg.Contact.PhoneNumber
+	if !strings.HasPrefix(phone, "+") {
+		phone = "+" + phone
+	}
+	user := sess.Scanner.userMap(b, sess.PublicURL)
+	user["phone_number"] = phone
+	l := approveSession(sess, user)
+	sessionsMu.Unlock()
+	removeKeyboard(completeTelegramLogin(sess, l, lang))
+}
+
+// approveSession moves a confirmed QR session to "approved" so no other
+// update can complete it, and returns its login. Caller must hold
+// sessionsMu.
+func approveSession(sess *Session, user map[string]any) *login {
+	sess.Status = "approved"
+	return &login{
+		Method:    "telegram",
+		User:      user,
+		Redirect:  sess.Redirect,
+		IP:        sess.IP,
+		UserAgent: sess.UserAgent,
+		Invite:    sess.Invite,
+		OrgInvite: sess.OrgInvite,
+	}
+}
+
+// completeTelegramLogin issues the code for an approved session and
+// returns the bot's reply in lang. It runs without sessionsMu: issuing may
+// call the Bot API (chat membership).
+func completeTelegramLogin(sess *Session, l *login, lang string) string {
+	var code string
+	var err error
+	if l.Redirect != "" {
+		code, err = issueCode(l)
+	} else {
+		redeemOrgInvite(l.OrgInvite, identityKey(l.Method, l.User["id"]))
+	}
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	if err != nil {
+		sess.Status = "denied"
+		sess.Error = err.Error()
+		return botMsg(lang, "denied_reason", err.Error())
+	}
+	sess.Code = code
+	sess.Status = "authenticated"
+	sess.User = l.User
+	return botMsg(lang, "authenticated")
+}
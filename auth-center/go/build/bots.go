@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+)
+
+// ── telegram bots ─────────────────────────────────────────────────────────
+//
+// One auth-center can serve several bots, e.g. one per product. BOT_TOKEN,
+// BOT_USERNAME, WEBHOOK_SECRET, WEBHOOK_URL and BOT_DESCRIPTION configure
+// the default bot, whose webhook stays at POST /webhook. More bots come
+// from the "bots" section of APPS_FILE and receive updates at
+// POST /webhook/{bot}:
+//
+//	{
+//	  "bots": {
+//	    "shop": {
+//	      "token":          "123:ABC",
+//	      "username":       "shop_auth_bot",
+//	      "webhook_secret": "<secret>",
+//	      "webhook_url":    "https://auth.example.com/webhook/shop",
+//	      "description":    "Log in to Shop"
+//	    }
+//	  }
+//	}
+//
+// An app's "bot" picks the bot of its QR login, login widget and messages;
+// without it the default bot is used. Updates are answered by the bot that
+// received them.
+
+type Bot struct {
+	ID          string `json:"-"` // "" for the default bot
+	Token       string `json:"token"`
+	Username    string `json:"username"`
+	Secret      string `json:"webhook_secret"`
+	WebhookURL  string `json:"webhook_url"`
+	Description string `json:"description"`
+}
+
+var (
+	defaultBot *Bot // nil without BOT_TOKEN
+	bots       = make(map[string]*Bot)
+)
+
+// allBots lists the configured bots, the default one first.
+func allBots() []*Bot {
+	var out []*Bot
+	if defaultBot != nil {
+		out = append(out, defaultBot)
+	}
+	ids := make([]string, 0, len(bots))
+	for id := range bots {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		out = append(out, bots[id])
+	}
+	return out
+}
+
+// appBot is the bot app logs in and messages with, nil if none is set up.
+func appBot(app *App) *Bot {
+	if app != nil && app.Bot != "" {
+		return bots[app.Bot]
+	}
+	return defaultBot
+}
+
+// botName labels b in logs and GET /status.
+func botName(b *Bot) string {
+	if b.ID == "" {
+		return "default"
+	}
+	return b.ID
+}
+
+// POST /webhook, POST /webhook/{bot}
+func handleWebhook(w http.ResponseWriter, r *http.Request) {
+	b := defaultBot
+	if id := r.PathValue("bot"); id != "" {
+		b = bots[id]
+	}
+	if b == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if b.Secret != "" {
+		if r.Header.Get("X-Telegram-Bot-Api-Secret-Token") != b.Secret {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+	}
+
+	var update tgUpdate
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	handleUpdate(b, &update)
+
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ── telegram bot setup ────────────────────────────────────────────────────
+//
+// With WEBHOOK_URL set, auth-center registers its webhook on startup
+// (secret_token, allowed_updates, optional drop_pending_updates), checks
+// the result with getWebhookInfo and sets the bot's commands and
+// description. Anything that does not match is logged and reported by
+// GET /status. Bots from APPS_FILE go through the same steps with their
+// own webhook_url and description.
+
+var (
+	webhookDropPending bool
+
+	// update types the bot handles, for setWebhook and getUpdates
+	allowedUpdates = []string{"message", "edited_message", "callback_query", "my_chat_member"}
+
+	// described by "cmd_<command>" in botText
+	botCommands = []string{"start", "sessions", "logout_all", "notifications", "help"}
+)
+
+type botStatus struct {
+	Mode         string    `json:"mode"`
+	Username     string    `json:"username,omitempty"`
+	WebhookURL   string    `json:"webhook_url,omitempty"`
+	PendingCount int       `json:"pending_update_count"`
+	LastError    string    `json:"last_error,omitempty"`
+	Problems     []string  `json:"problems"`
+	CheckedAt    time.Time `json:"checked_at"`
+}
+
+var (
+	botStatusCache   = make(map[string]botStatus) // botName → last check
+	botStatusCacheMu sync.Mutex
+
+	setupClient = &http.Client{Timeout: 10 * time.Second, Transport: telegramTransport}
+)
+
+const botStatusMaxAge = time.Minute
+
+func setupBots() {
+	for _, b := range allBots() {
+		setupBot(b)
+	}
+}
+
+// setupBot registers the webhook and bot profile, logging every problem.
+func setupBot(b *Bot) {
+	logf := func(format string, args ...any) {
+		log.Printf("telegram %s: "+format, append([]any{botName(b)}, args...)...)
+	}
+	if b.WebhookURL != "" && botMode != "polling" {
+		err := tgRequest(b, setupClient, "setWebhook", map[string]any{
+			"url":                  b.WebhookURL,
+			"secret_token":         b.Secret,
+			"allowed_updates":      allowedUpdates,
+			"drop_pending_updates": webhookDropPending,
+		}, nil)
+		if err != nil {
+			logf("%v", err)
+		}
+	}
+	// English for everyone, other catalogs for their language_code
+	for lang := range botText {
+		payload := map[string]any{"commands": commandList(lang)}
+		if lang != defaultLang {
+			payload["language_code"] = lang
+		}
+		if err := tgRequest(b, setupClient, "setMyCommands", payload, nil); err != nil {
+			logf("%v", err)
+		}
+	}
+	if b.Description != "" {
+		err := tgRequest(b, setupClient, "setMyDescription", map[string]any{"description": b.Description}, nil)
+		if err != nil {
+			logf("%v", err)
+		}
+	}
+
+	st := refreshBotStatus(b)
+	for _, p := range st.Problems {
+		logf("%s", p)
+	}
+}
+
+// commandList is the command menu in lang.
+func commandList(lang string) []map[string]string {
+	var out []map[string]string
+	for _, c := range botCommands {
+		out = append(out, map[string]string{"command": c, "description": botMsg(lang, "cmd_"+c)})
+	}
+	return out
+}
+
+// statusProblem words a failed check for GET /status, which anyone can
+// read: Bot API refusals as Telegram put them, anything else — network
+// and proxy errors — only in the log.
+func statusProblem(b *Bot, method string, err error) string {
+	var apiErr *tgAPIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Error()
+	}
+	log.Printf("telegram %s: %v", botName(b), err)
+	return method + ": telegram is unreachable"
+}
+
+// checkBot compares what Telegram reports with our configuration.
+func checkBot(b *Bot) botStatus {
+	st := botStatus{Mode: "webhook", Problems: []string{}, CheckedAt: time.Now()}
+	if botMode == "polling" {
+		st.Mode = "polling"
+	}
+	if b == nil {
+		st.Problems = append(st.Problems, "BOT_TOKEN is not set")
+		return st
+	}
+
+	var me struct {
+		Username string `json:"username"`
+	}
+	if err := tgRequest(b, setupClient, "getMe", map[string]any{}, &me); err != nil {
+		st.Problems = append(st.Problems, statusProblem(b, "getMe", err))
+		return st
+	}
+	st.Username = me.Username
+	if b.Username != "" && me.Username != b.Username {
+		st.Problems = append(st.Problems, fmt.Sprintf("username is %q but the token belongs to %q", b.Username, me.Username))
+	}
+
+	var info struct {
+		URL                string   `json:"url"`
+		PendingUpdateCount int      `json:"pending_update_count"`
+		LastErrorMessage   string   `json:"last_error_message"`
+		AllowedUpdates     []string `json:"allowed_updates"`
+	}
+	if err := tgRequest(b, setupClient, "getWebhookInfo", map[string]any{}, &info); err != nil {
+		st.Problems = append(st.Problems, statusProblem(b, "getWebhookInfo", err))
+		return st
+	}
+	st.WebhookURL = info.URL
+	st.PendingCount = info.PendingUpdateCount
+	st.LastError = info.LastErrorMessage
+
+	switch {
+	case botMode == "polling" && info.URL != "":
+		st.Problems = append(st.Problems, "polling mode but a webhook is registered at "+info.URL)
+	case botMode != "polling" && b.WebhookURL != "" && info.URL != b.WebhookURL:
+		st.Problems = append(st.Problems, fmt.Sprintf("webhook is %q, expected %q", info.URL, b.WebhookURL))
+	case botMode != "polling" && info.URL == "":
+		st.Problems = append(st.Problems, "no webhook registered")
+	}
+	if info.URL != "" && len(info.AllowedUpdates) > 0 {
+		for _, u := range allowedUpdates {
+			if !slices.Contains(info.AllowedUpdates, u) {
+				st.Problems = append(st.Problems, "webhook does not receive "+u+" updates")
+			}
+		}
+	}
+	if info.LastErrorMessage != "" {
+		st.Problems = append(st.Problems, "last webhook error: "+info.LastErrorMessage)
+	}
+	return st
+}
+
+func refreshBotStatus(b *Bot) botStatus {
+	st := checkBot(b)
+	botStatusCacheMu.Lock()
+	botStatusCache[botName(b)] = st
+	botStatusCacheMu.Unlock()
+	return st
+}
+
+// GET /status
+//
+// "telegram" is the default bot, "bots" the ones from APPS_FILE.
+func handleStatus(w http.ResponseWriter, r *http.Request) {
+	resp := map[string]any{}
+	ok := true
+	named := make(map[string]botStatus)
+	for _, b := range allBots() {
+		botStatusCacheMu.Lock()
+		st, cached := botStatusCache[botName(b)]
+		botStatusCacheMu.Unlock()
+		if !cached || time.Since(st.CheckedAt) > botStatusMaxAge {
+			st = refreshBotStatus(b)
+		}
+		ok = ok && len(st.Problems) == 0
+		if b == defaultBot {
+			resp["telegram"] = st
+		} else {
+			named[b.ID] = st
+		}
+	}
+	if defaultBot == nil && len(named) == 0 {
+		resp["telegram"] = checkBot(nil)
+		ok = false
+	}
+	if len(named) > 0 {
+		resp["bots"] = named
+	}
+	resp["ok"] = ok
+	jsonOK(w, resp)
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ── localization ──────────────────────────────────────────────────────────
+//
+// The login page and the bot speak English and Russian. The page takes
+// ?lang=, then Accept-Language; the bot answers in the sender's Telegram
+// language_code. Failing that — and for messages the bot starts itself,
+// like login alerts — the app's "locale" from APPS_FILE applies, then
+// English. A key missing from a catalog falls back to English.
+
+const defaultLang = "en"
+
+// pageText is the login page, including the strings set by script.js.
+var pageText = map[string]map[string]string{
+	"en": {
+		"invite_placeholder": "invite code",
+		"invite_hint":        "needed on your first login only",
+		"continue_google":    "continue with google",
+		"open_telegram":      "open in telegram",
+		"tap_number":         "tap this number in telegram",
+		"refresh":            "refresh",
+		"connect_wallet":     "connect wallet",
+		"no_wallet":          "no wallet detected —",
+		"open_phantom":       "open in phantom",
+		"choose_org":         "choose organization",
+		"approve_telegram":   "approve this login in telegram",
+		"org_failed":         "organization selection failed",
+		"login_denied":       "login denied",
+		"access_denied":      "access denied",
+		"signing":            "signing...",
+		"opening_wallet":     "opening wallet...",
+		"connecting":         "connecting...",
+		"looking_wallet":     "looking for wallet...",
+		"connected":          "connected",
+		"auth_failed":        "auth failed",
+	},
+	"ru": {
+		"invite_placeholder": "код приглашения",
+		"invite_hint":        "нужен только при первом входе",
+		"continue_google":    "войти через google",
+		"open_telegram":      "открыть в telegram",
+		"tap_number":         "нажми это число в telegram",
+		"refresh":            "обновить",
+		"connect_wallet":     "подключить кошелёк",
+		"no_wallet":          "кошелёк не найден —",
+		"open_phantom":       "открыть в phantom",
+		"choose_org":         "выбери организацию",
+		"approve_telegram":   "подтверди вход в telegram",
+		"org_failed":         "не удалось выбрать организацию",
+		"login_denied":       "вход отклонён",
+		"access_denied":      "доступ запрещён",
+		"signing":            "подпись...",
+		"opening_wallet":     "открываем кошелёк...",
+		"connecting":         "подключение...",
+		"looking_wallet":     "ищем кошелёк...",
+		"connected":          "подключено",
+		"auth_failed":        "вход не удался",
+	},
+}
+
+// botText is everything the bot says; values are fmt formats.
+var botText = map[string]map[string]string{
+	"en": {
+		"cmd_start":         "Log in",
+		"cmd_sessions":      "Recent logins",
+		"cmd_logout_all":    "Log out everywhere",
+		"cmd_notifications": "Muted apps",
+		"cmd_help":          "What this bot does",
+
+		"help":            "I confirm logins for apps that use auth-center. To log in, scan the QR code on the login page.\n",
+		"unknown_command": "Unknown command %s.\n\n",
+
+		"suspended":     "This account is suspended.",
+		"qr_expired":    "This QR code has expired.",
+		"confirm_login": "Log in to %s?\n\nIP: %s\nBrowser: %s\n\nTap the number shown on the login page. If you did not start this login, tap Deny.",
+		"approve":       "Approve",
+		"deny":          "Deny",
+		"expired":       "This login request has expired.",
+		"denied":        "Login denied.",
+		"denied_reason": "Login denied: %s.",
+		"wrong_number":  "Wrong number. Login denied — start again from the login page.",
+		"number_ok":     "Number confirmed.",
+		"ask_phone":     "This app asks for your phone number. Share it to finish logging in.",
+		"share_phone":   "Share phone number",
+		"own_contact":   "Please share your own contact with the button below.",
+		"authenticated": "You are authenticated!",
+
+		"no_logins":     "No active logins.",
+		"recent_logins": "Your recent logins:\n",
+		"login_line":    "\n%d. %s — %s\n   %s, IP %s\n   %s\n",
+		"revoke_n":      "Revoke %d (%s)",
+		"logout_all":    "Log out everywhere",
+		"revoked":       "Login revoked.",
+		"logged_out":    "Logged out of %d session(s).",
+		"not_active":    "This login is no longer active.",
+
+		"new_login": "New login to %s\n\nMethod: %s\nIP: %s\nBrowser: %s\nTime: %s",
+		"not_me":    "This wasn't me",
+		"flagged":   "Login revoked. The account has been flagged for review.",
+
+		"approve_login": "Approve login to %s?\n\nMethod: %s\nIP: %s\nBrowser: %s",
+		"approved":      "Login approved.",
+
+		"bc_confirm":       "%s asks you to confirm that it is really you.",
+		"bc_not_you":       "If you are not talking to them right now, tap Deny.",
+		"bc_expired":       "This request has expired.",
+		"bc_confirmed":     "Confirmed.",
+		"bc_denied":        "Denied.",
+		"bc_denied_reason": "Denied: %s.",
+
+		"mute":       "Mute %s",
+		"unmute":     "Unmute %s",
+		"muted":      "You won't get messages from %s. Send /notifications to undo.",
+		"unmuted":    "%s can message you again.",
+		"no_muted":   "No apps are muted.",
+		"muted_apps": "Muted apps:",
+	},
+	"ru": {
+		"cmd_start":         "Войти",
+		"cmd_sessions":      "Последние входы",
+		"cmd_logout_all":    "Выйти везде",
+		"cmd_notifications": "Отключённые приложения",
+		"cmd_help":          "Что умеет бот",
+
+		"help":            "Я подтверждаю входы в приложения, которые используют auth-center. Чтобы войти, отсканируй QR-код на странице входа.\n",
+		"unknown_command": "Неизвестная команда %s.\n\n",
+
+		"suspended":     "Этот аккаунт заблокирован.",
+		"qr_expired":    "Срок действия QR-кода истёк.",
+		"confirm_login": "Войти в %s?\n\nIP: %s\nБраузер: %s\n\nНажми число, которое показано на странице входа. Если вход начал не ты, нажми «Отклонить».",
+		"approve":       "Подтвердить",
+		"deny":          "Отклонить",
+		"expired":       "Срок действия запроса на вход истёк.",
+		"denied":        "Вход отклонён.",
+		"denied_reason": "Вход отклонён: %s.",
+		"wrong_number":  "Неверное число. Вход отклонён — начни заново со страницы входа.",
+		"number_ok":     "Число подтверждено.",
+		"ask_phone":     "Приложение запрашивает номер телефона. Поделись им, чтобы завершить вход.",
+		"share_phone":   "Поделиться номером",
+		"own_contact":   "Поделись своим контактом кнопкой ниже.",
+		"authenticated": "Вход выполнен!",
+
+		"no_logins":     "Активных входов нет.",
+		"recent_logins": "Последние входы:\n",
+		"login_line":    "\n%d. %s — %s\n   %s, IP %s\n   %s\n",
+		"revoke_n":      "Завершить %d (%s)",
+		"logout_all":    "Выйти везде",
+		"revoked":       "Вход завершён.",
+		"logged_out":    "Завершено сеансов: %d.",
+		"not_active":    "Этот вход уже не активен.",
+
+		"new_login": "Новый вход в %s\n\nСпособ: %s\nIP: %s\nБраузер: %s\nВремя: %s",
+		"not_me":    "Это был не я",
+		"flagged":   "Вход завершён. Аккаунт отправлен на проверку.",
+
+		"approve_login": "Подтвердить вход в %s?\n\nСпособ: %s\nIP: %s\nБраузер: %s",
+		"approved":      "Вход подтверждён.",
+
+		"bc_confirm":       "%s просит подтвердить, что это действительно ты.",
+		"bc_not_you":       "Если ты сейчас не общаешься с ними, нажми «Отклонить».",
+		"bc_expired":       "Срок действия запроса истёк.",
+		"bc_confirmed":     "Подтверждено.",
+		"bc_denied":        "Отклонено.",
+		"bc_denied_reason": "Отклонено: %s.",
+
+		"mute":       "Отключить %s",
+		"unmute":     "Включить %s",
+		"muted":      "Сообщения от %s больше не придут. Вернуть — /notifications.",
+		"unmuted":    "%s снова может писать тебе.",
+		"no_muted":   "Отключённых приложений нет.",
+		"muted_apps": "Отключённые приложения:",
+	},
+}
+
+// matchLang maps a language tag ("ru-RU", "en_US") to a supported
+// language, "" if there is none.
+func matchLang(tag string) string {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if i := strings.IndexAny(tag, "-_"); i >= 0 {
+		tag = tag[:i]
+	}
+	if _, ok := pageText[tag]; ok {
+		return tag
+	}
+	return ""
+}
+
+// appLang is the app's default locale.
+func appLang(app *App) string {
+	if app != nil && app.Locale != "" {
+		return matchLang(app.Locale)
+	}
+	return defaultLang
+}
+
+// pageLang picks the login page language for r.
+func pageLang(r *http.Request, app *App) string {
+	if lang := matchLang(r.URL.Query().Get("lang")); lang != "" {
+		return lang
+	}
+	type tag struct {
+		name string
+		q    float64
+	}
+	var tags []tag
+	for _, part := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		name, params, _ := strings.Cut(part, ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		if q > 0 {
+			tags = append(tags, tag{name, q})
+		}
+	}
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].q > tags[j].q })
+	for _, t := range tags {
+		if lang := matchLang(t.name); lang != "" {
+			return lang
+		}
+	}
+	return appLang(app)
+}
+
+// userLang picks the language of a bot reply to u.
+func userLang(u tgUser, app *App) string {
+	if lang := matchLang(u.LanguageCode); lang != "" {
+		return lang
+	}
+	return appLang(app)
+}
+
+// botMsg formats message key of the bot catalog in lang.
+func botMsg(lang, key string, args ...any) string {
+	format, ok := botText[lang][key]
+	if !ok {
+		format, ok = botText[defaultLang][key]
+	}
+	if !ok {
+		return key
+	}
+	if len(args) == 0 {
+		return format
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+// pageMsgs is the page catalog for lang, English filling the gaps.
+func pageMsgs(lang string) map[string]string {
+	out := make(map[string]string, len(pageText[defaultLang]))
+	for k, v := range pageText[defaultLang] {
+		out[k] = v
+	}
+	for k, v := range pageText[lang] {
+		out[k] = v
+	}
+	return out
+}
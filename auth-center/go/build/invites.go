@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+)
+
+// ── invite-only apps ──────────────────────────────────────────────────────
+//
+// An app with "invite_only": true admits an identity for the first time
+// only with a valid invite code created through the admin API. Once in,
+// the identity (and every identity linked to it) logs in without a code.
+
+var errInviteRequired = errors.New("invite required")
+
+type AppInvite struct {
+	App       string     `json:"app"`
+	MaxUses   int        `json:"max_uses"`
+	Uses      int        `json:"uses"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+func (inv *AppInvite) valid() bool {
+	if inv.ExpiresAt != nil && time.Now().After(*inv.ExpiresAt) {
+		return false
+	}
+	return inv.MaxUses == 0 || inv.Uses < inv.MaxUses
+}
+
+// admitToApp lets identity into an invite-only app, requiring invite if it
+// has never logged in there before. With use set the invite is spent and
+// the identity registered; without, admitToApp only checks.
+func admitToApp(app *App, identity, invite string, use bool) error {
+	if app == nil || !app.InviteOnly {
+		return nil
+	}
+	storeMu.Lock()
+	defer storeMu.Unlock()
+
+	registered := store.Registered[app.ID]
+	for _, id := range linkedIdentities(identity) {
+		if _, ok := registered[id]; ok {
+			return nil
+		}
+	}
+
+	inv, ok := store.AppInvites[invite]
+	if !ok || inv.App != app.ID || !inv.valid() {
+		return errInviteRequired
+	}
+	if !use {
+		return nil
+	}
+	inv.Uses++
+	if registered == nil {
+		registered = make(map[string]time.Time)
+		store.Registered[app.ID] = registered
+	}
+	registered[identity] = time.Now()
+	if err := saveStore(); err != nil {
+		log.Printf("save store: %v", err)
+	}
+	return nil
+}
+
+// GET /admin/apps/{app}/invites
+func handleAdminListAppInvites(w http.ResponseWriter, r *http.Request) {
+	app := r.PathValue("app")
+	storeMu.Lock()
+	defer storeMu.Unlock()
+	out := make(map[string]*AppInvite)
+	for code, inv := range store.AppInvites {
+		if inv.App == app {
+			out[code] = inv
+		}
+	}
+	jsonOK(w, out)
+}
+
+// POST /admin/apps/{app}/invites
+func handleAdminCreateAppInvite(w http.ResponseWriter, r *http.Request) {
+	inv := AppInvite{MaxUses: 1}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&inv); err != nil {
+			jsonErr(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+	}
+	inv.App = r.PathValue("app")
+	if _, ok := apps[inv.App]; !ok {
+		jsonErr(w, "not found", http.StatusNotFound)
+		return
+	}
+	inv.Uses = 0
+	inv.CreatedAt = time.Now()
+	code := randHex(6)
+
+	storeMu.Lock()
+	defer storeMu.Unlock()
+	store.AppInvites[code] = &inv
+	if err := saveStore(); err != nil {
+		jsonErr(w, "save failed", http.StatusInternalServerError)
+		return
+	}
+	jsonOK(w, map[string]any{"code": code, "invite": &inv})
+}
+
+// DELETE /admin/apps/{app}/invites/{code}
+func handleAdminDeleteAppInvite(w http.ResponseWriter, r *http.Request) {
+	storeMu.Lock()
+	defer storeMu.Unlock()
+	code := r.PathValue("code")
+	if inv, ok := store.AppInvites[code]; !ok || inv.App != r.PathValue("app") {
+		jsonErr(w, "not found", http.StatusNotFound)
+		return
+	}
+	delete(store.AppInvites, code)
+	if err := saveStore(); err != nil {
+		jsonErr(w, "save failed", http.StatusInternalServerError)
+		return
+	}
+	jsonOK(w, map[string]any{"ok": true})
+}
+
+// inviteRequired tells the login page whether to ask for an invite code.
+func inviteRequired(redirect string) bool {
+	app := appForRedirect(redirect)
+	return app != nil && app.InviteOnly
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ── login alerts ──────────────────────────────────────────────────────────
+//
+// Every successful login, by any method, is announced in Telegram to each
+// Telegram identity linked to the one that logged in. A Telegram identity
+// is not told about its own logins: it has just confirmed them in the
+// chat (QR, backchannel) or in Telegram itself. The message carries
+// a "This wasn't me" button: it revokes that login and flags the identity
+// for review by an admin (GET /admin/flagged).
+
+type ReviewFlag struct {
+	Login     string    `json:"login"`
+	Reporter  string    `json:"reporter"`
+	Method    string    `json:"method"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// notifyLogin sends the alert for loginID to the user's Telegram chats.
+func notifyLogin(loginID string) {
+	storeMu.Lock()
+	rec, ok := store.Logins[loginID]
+	var ids []string
+	if ok {
+		ids = linkedIdentities(rec.Identity)
+	}
+	storeMu.Unlock()
+	if !ok {
+		return
+	}
+	b := appBot(apps[rec.App])
+	if b == nil {
+		return
+	}
+
+	lang := appLang(apps[rec.App])
+	text := botMsg(lang, "new_login",
+		rec.AppLabel, rec.Method, rec.IP, rec.UserAgent, rec.CreatedAt.UTC().Format("2006-01-02 15:04 UTC"),
+	)
+	for _, id := range ids {
+		raw, ok := strings.CutPrefix(id, "telegram:")
+		if !ok || id == rec.Identity {
+			continue
+		}
+		chatID, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			continue
+		}
+		tgCall(b, "sendMessage", map[string]any{
+			"chat_id": chatID,
+			"text":    text,
+			"reply_markup": map[string]any{
+				"inline_keyboard": [][]map[string]string{
+					{{"text": botMsg(lang, "not_me"), "callback_data": "notme:" + loginID}},
+				},
+			},
+		})
+	}
+}
+
+// handleNotMeCallback revokes the reported login and flags its identity.
+func handleNotMeCallback(b *Bot, cq *tgCallbackQuery, loginID string) {
+	reporter := identityKey("telegram", cq.From.ID)
+
+	storeMu.Lock()
+	rec, ok := store.Logins[loginID]
+	own := ok && slices.Contains(linkedIdentities(reporter), rec.Identity)
+	if own {
+		store.Flagged[rec.Identity] = &ReviewFlag{
+			Login:     loginID,
+			Reporter:  reporter,
+			Method:    rec.Method,
+			IP:        rec.IP,
+			UserAgent: rec.UserAgent,
+			CreatedAt: time.Now(),
+		}
+		if err := saveStore(); err != nil {
+			log.Printf("save store: %v", err)
+		}
+	}
+	storeMu.Unlock()
+
+	lang := userLang(cq.From, nil)
+	text := botMsg(lang, "expired")
+	if own {
+		revokeLogins([]string{loginID})
+		log.Printf("login %s reported by %s, %s flagged for review", loginID, reporter, rec.Identity)
+		text = botMsg(lang, "flagged")
+	}
+	answerCallback(b, cq, "")
+	if cq.Message != nil {
+		tgCall(b, "editMessageText", map[string]any{
+			"chat_id":    cq.Message.Chat.ID,
+			"message_id": cq.Message.MessageID,
+			"text":       cq.Message.Text + "\n\n" + text,
+		})
+	}
+}
+
+// ── login alerts: admin ───────────────────────────────────────────────────
+
+// GET /admin/flagged
+func handleAdminListFlagged(w http.ResponseWriter, r *http.Request) {
+	storeMu.Lock()
+	defer storeMu.Unlock()
+	jsonOK(w, store.Flagged)
+}
+
+// DELETE /admin/flagged/{identity}
+func handleAdminClearFlag(w http.ResponseWriter, r *http.Request) {
+	storeMu.Lock()
+	delete(store.Flagged, r.PathValue("identity"))
+	err := saveStore()
+	storeMu.Unlock()
+	if err != nil {
+		jsonErr(w, "save failed", http.StatusInternalServerError)
+		return
+	}
+	jsonOK(w, map[string]any{"ok": true})
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ── login history ─────────────────────────────────────────────────────────
+//
+// Every issued code is recorded as a login: who, which app, method, IP
+// and browser. /exchange returns its id as session_id; an app that keeps
+// its own session checks POST /session/check to learn about revocation.
+// Users see and revoke their logins from the bot with /sessions and
+// /logout_all.
+// Logins are frequent, so they reach DATA_FILE through the background
+// save (see runStoreFlush), not one rewrite each.
+
+const (
+	loginHistoryTTL = 30 * 24 * time.Hour
+	sessionsShown   = 10
+)
+
+type LoginRecord struct {
+	Identity  string    `json:"identity"`
+	App       string    `json:"app,omitempty"`
+	AppLabel  string    `json:"app_label"`
+	Method    string    `json:"method"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	CreatedAt time.Time `json:"created_at"`
+	Revoked   bool      `json:"revoked"`
+}
+
+// recordLogin stores l and returns the new login id.
+func recordLogin(l *login, identity string, app *App) string {
+	rec := &LoginRecord{
+		Identity:  identity,
+		AppLabel:  appLabel(l.Redirect),
+		Method:    l.Method,
+		IP:        l.IP,
+		UserAgent: l.UserAgent,
+		CreatedAt: time.Now(),
+	}
+	if app != nil {
+		rec.App = app.ID
+		rec.AppLabel = appName(app)
+	}
+	id := randToken(12)
+
+	storeMu.Lock()
+	defer storeMu.Unlock()
+	store.Logins[id] = rec
+	storeDirty = true
+	return id
+}
+
+// registerIdentity remembers for good that identity logged in to app: who
+// may get its notifications and backchannel requests.
+func registerIdentity(app *App, identity string) {
+	storeMu.Lock()
+	defer storeMu.Unlock()
+	if store.Registered[app.ID] == nil {
+		store.Registered[app.ID] = make(map[string]time.Time)
+	}
+	if _, ok := store.Registered[app.ID][identity]; ok {
+		return
+	}
+	store.Registered[app.ID][identity] = time.Now()
+	if err := saveStore(); err != nil {
+		log.Printf("save store: %v", err)
+	}
+}
+
+// pruneLogins drops logins past loginHistoryTTL. Caller must hold
+// storeMu.
+func pruneLogins() {
+	for k, r := range store.Logins {
+		if time.Since(r.CreatedAt) > loginHistoryTTL {
+			delete(store.Logins, k)
+			storeDirty = true
+		}
+	}
+}
+
+type loginEntry struct {
+	ID string
+	*LoginRecord
+}
+
+// activeLogins lists the unrevoked logins of ids, newest first.
+// Caller must hold storeMu.
+func activeLogins(ids []string) []loginEntry {
+	var out []loginEntry
+	for id, r := range store.Logins {
+		if !r.Revoked && slices.Contains(ids, r.Identity) {
+			out = append(out, loginEntry{id, r})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.After(out[j].CreatedAt) })
+	return out
+}
+
+// revokeLogins marks the logins revoked and drops their unexchanged codes.
+func revokeLogins(loginIDs []string) {
+	if len(loginIDs) == 0 {
+		return
+	}
+	storeMu.Lock()
+	for _, id := range loginIDs {
+		if r, ok := store.Logins[id]; ok {
+			r.Revoked = true
+		}
+	}
+	if err := saveStore(); err != nil {
+		log.Printf("save store: %v", err)
+	}
+	storeMu.Unlock()
+
+	codesMu.Lock()
+	for k, c := range codes {
+		if slices.Contains(loginIDs, c.Login) {
+			delete(codes, k)
+		}
+	}
+	codesMu.Unlock()
+}
+
+// POST /session/check
+func handleSessionCheck(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		SessionID string `json:"session_id"`
+		AppToken  string `json:"app_token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		jsonErr(w, "no data", http.StatusBadRequest)
+		return
+	}
+	app := appByToken(body.AppToken)
+	if (len(appTokens) > 0 || len(apps) > 0) && !appTokens[body.AppToken] && app == nil {
+		jsonErr(w, "unauthorized", http.StatusForbidden)
+		return
+	}
+
+	storeMu.Lock()
+	rec, ok := store.Logins[body.SessionID]
+	active := ok && !rec.Revoked && (rec.App == "" || (app != nil && app.ID == rec.App))
+	storeMu.Unlock()
+	jsonOK(w, map[string]any{"ok": true, "active": active})
+}
+
+// ── login history: bot ────────────────────────────────────────────────────
+
+// handleSessions answers /sessions with the user's recent logins and a
+// revoke button for each.
+func handleSessions(b *Bot, from tgUser) {
+	storeMu.Lock()
+	list := activeLogins(linkedIdentities(identityKey("telegram", from.ID)))
+	storeMu.Unlock()
+
+	lang := userLang(from, nil)
+	if len(list) == 0 {
+		sendTG(b, from.ID, botMsg(lang, "no_logins"))
+		return
+	}
+	if len(list) > sessionsShown {
+		list = list[:sessionsShown]
+	}
+
+	var text strings.Builder
+	text.WriteString(botMsg(lang, "recent_logins"))
+	var keyboard [][]map[string]string
+	for i, e := range list {
+		text.WriteString(botMsg(lang, "login_line",
+			i+1, e.AppLabel, e.Method, e.CreatedAt.UTC().Format("2006-01-02 15:04 UTC"), e.IP, e.UserAgent))
+		keyboard = append(keyboard, []map[string]string{{
+			"text":          botMsg(lang, "revoke_n", i+1, e.AppLabel),
+			"callback_data": "revoke:" + e.ID,
+		}})
+	}
+	keyboard = append(keyboard, []map[string]string{{
+		"text": botMsg(lang, "logout_all"), "callback_data": "logout_all",
+	}})
+	tgCall(b, "sendMessage", map[string]any{
+		"chat_id":      from.ID,
+		"text":         text.String(),
+		"reply_markup": map[string]any{"inline_keyboard": keyboard},
+	})
+}
+
+// logoutAll revokes every login of the user's identities and returns how
+// many there were.
+func logoutAll(from tgUser) int {
+	storeMu.Lock()
+	var ids []string
+	for _, e := range activeLogins(linkedIdentities(identityKey("telegram", from.ID))) {
+		ids = append(ids, e.ID)
+	}
+	storeMu.Unlock()
+	revokeLogins(ids)
+	return len(ids)
+}
+
+// revokeOwnLogin revokes loginID if it belongs to the user.
+func revokeOwnLogin(from tgUser, loginID string) bool {
+	storeMu.Lock()
+	rec, ok := store.Logins[loginID]
+	own := ok && slices.Contains(linkedIdentities(identityKey("telegram", from.ID)), rec.Identity)
+	storeMu.Unlock()
+	if !own {
+		return false
+	}
+	revokeLogins([]string{loginID})
+	return true
+}
+
+// handleRevokeCallback serves the buttons under the /sessions list.
+func handleRevokeCallback(b *Bot, cq *tgCallbackQuery, action, loginID string) {
+	lang := userLang(cq.From, nil)
+	text := botMsg(lang, "revoked")
+	switch {
+	case action == "logout_all":
+		text = botMsg(lang, "logged_out", logoutAll(cq.From))
+	case !revokeOwnLogin(cq.From, loginID):
+		text = botMsg(lang, "not_active")
+	}
+	answerCallback(b, cq, text)
+	if action == "logout_all" && cq.Message != nil {
+		tgCall(b, "editMessageText", map[string]any{
+			"chat_id":    cq.Message.Chat.ID,
+			"message_id": cq.Message.MessageID,
+			"text":       text,
+		})
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"crypto/ed25519"
 	"crypto/rand"
 	"embed"
@@ -13,11 +14,14 @@ import (
 	"image/color"
 	"io/fs"
 	"log"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/joho/godotenv"
@@ -33,9 +37,6 @@ var webFiles embed.FS
 // ── config ────────────────────────────────────────────────────────────────
 
 var (
-	botToken       string
-	botUsername    string
-	webhookSecret  string
 	appTokens      map[string]bool
 	directRedirect string
 
@@ -46,11 +47,13 @@ var (
 	googleStates   = make(map[string]googleState)
 	googleStatesMu sync.Mutex
 
-	httpClient = &http.Client{Timeout: 10 * time.Second}
+	httpClient = &http.Client{Timeout: 10 * time.Second, Transport: googleTransport}
 )
 
 type googleState struct {
 	Redirect  string
+	Invite    string
+	OrgInvite string
 	CreatedAt time.Time
 }
 
@@ -62,12 +65,45 @@ type Session struct {
 	CreatedAt time.Time
 	Redirect  string
 	Code      string
+	IP        string
+	UserAgent string
+	Invite    string
+	OrgInvite string
+	Error     string
+	Scanner   *tgUser // who opened the QR link, waiting for approval
+	Number    int     // shown on the page, must be picked in the bot
+	NeedPhone bool    // number matched, waiting for the shared contact
+	PublicURL string  // auth-center as seen by the browser, for picture links
+	Bot       *Bot    // the bot in the QR deep link
 }
 
 type Code struct {
 	User      map[string]any
 	Method    string
+	App       string
+	Claims    map[string]any
+	Org       *orgChoice
+	Orgs      []orgChoice // set while the user still has to pick one
+	Login     string      // LoginRecord id, returned as session_id
+	Approval  string      // "pending" while waiting for the telegram second factor
+	AMR       []string    // methods used, when more than one
 	CreatedAt time.Time
+
+	// held for approval: the invites to apply once approved
+	invite, orgInvite string
+	admitting         bool // approved, being admitted
+}
+
+// login is one verified identity on its way to a one-time code.
+type login struct {
+	Method    string
+	User      map[string]any
+	Redirect  string
+	IP        string
+	UserAgent string
+	Invite    string
+	OrgInvite string
+	App       *App // set when there is no redirect to resolve it from
 }
 
 var (
@@ -82,8 +118,9 @@ var (
 )
 
 const (
-	sessionTTL = 5 * time.Minute
-	codeTTL    = 60 * time.Second
+	sessionTTL      = 5 * time.Minute
+	codeTTL         = 60 * time.Second
+	shutdownTimeout = 10 * time.Second
 )
 
 // ── helpers ───────────────────────────────────────────────────────────────
@@ -139,26 +176,216 @@ func cleanCodes() {
 	codesMu.Lock()
 	defer codesMu.Unlock()
 	for k, v := range codes {
-		if time.Since(v.CreatedAt) > codeTTL {
+		ttl := codeTTL
+		if v.Approval != "" {
+			ttl = approvalTTL
+		}
+		if time.Since(v.CreatedAt) > ttl {
 			delete(codes, k)
 		}
 	}
 }
 
-func newCode(user map[string]any, method string) string {
+// trustedProxies (TRUSTED_PROXIES: IPs and CIDRs) may set X-Real-IP,
+// X-Forwarded-For and X-Forwarded-Proto; from anyone else those headers
+// are ignored.
+var trustedProxies []*net.IPNet
+
+// parseProxies parses a comma-separated list of IPs and CIDRs.
+func parseProxies(raw string) ([]*net.IPNet, error) {
+	var out []*net.IPNet
+	for _, s := range strings.Split(raw, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address %q", s)
+			}
+			bits := 8 * len(ip.To16())
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			out = append(out, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, n)
+	}
+	return out, nil
+}
+
+func trusted(ip string) bool {
+	addr := net.ParseIP(ip)
+	for _, n := range trustedProxies {
+		if addr != nil && n.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// clientIP is the caller's address: the peer itself, or what a trusted
+// reverse proxy in front of us reports.
+func clientIP(r *http.Request) string {
+	ip := remoteHost(r)
+	if !trusted(ip) {
+		return ip
+	}
+	if xrip := strings.TrimSpace(r.Header.Get("X-Real-IP")); xrip != "" {
+		return xrip
+	}
+	// the nearest hop not run by us is the client
+	hops := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		ip = hop
+		if !trusted(hop) {
+			break
+		}
+	}
+	return ip
+}
+
+// publicURL is the scheme and host this request reached us on.
+func publicURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil || (trusted(remoteHost(r)) && r.Header.Get("X-Forwarded-Proto") == "https") {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// issueCode runs the app's rules for l and stores a one-time code.
+func issueCode(l *login) (string, error) {
+	app := l.App
+	if app == nil {
+		app = appForRedirect(l.Redirect)
+	}
+	// only the QR flow asks Telegram for the phone number
+	if l.Method == "telegram" && app.wants("phone") && l.User["phone_number"] == nil {
+		return "", errPhoneRequired
+	}
+	claims, err := evalRules(app, l)
+	if err != nil {
+		return "", err
+	}
+
+	identity := identityKey(l.Method, l.User["id"])
+	chats, err := checkChats(app, identity)
+	if err != nil {
+		return "", err
+	}
+	if chats != nil {
+		if claims == nil {
+			claims = make(map[string]any)
+		}
+		claims["chats"] = chats
+	}
+	if err := admitToApp(app, identity, l.Invite, false); err != nil {
+		return "", err
+	}
+
 	cleanCodes()
 	c := randToken(32)
+	entry := &Code{User: l.User, Method: l.Method, Claims: claims, CreatedAt: time.Now()}
+	if app != nil {
+		entry.App = app.ID
+	}
+	entry.Login = recordLogin(l, identity, app)
+	// the approval prompt doubles as the login alert; what the login
+	// grants waits for the approval
+	if app != nil && holdForApproval(entry, l, identity, app) {
+		entry.invite, entry.orgInvite = l.Invite, l.OrgInvite
+	} else {
+		orgs, err := admitLogin(app, identity, l.Invite, l.OrgInvite)
+		if err != nil {
+			revokeLogins([]string{entry.Login})
+			return "", err
+		}
+		entry.setOrgs(orgs)
+		go notifyLogin(entry.Login)
+	}
 	codesMu.Lock()
-	codes[c] = &Code{User: user, Method: method, CreatedAt: time.Now()}
+	codes[c] = entry
 	codesMu.Unlock()
-	return c
+	return c, nil
 }
 
-func sendTG(chatID int64, text string) {
-	url := fmt.Sprintf("https://api.telegram.org/bot%s/sendMessage", botToken)
-	body, _ := json.Marshal(map[string]any{"chat_id": chatID, "text": text})
-	client := &http.Client{Timeout: 5 * time.Second}
-	client.Post(url, "application/json", bytes.NewReader(body)) //nolint:errcheck
+// admitLogin applies what a login grants once it is let through: the app
+// invite is spent, the org invite redeemed and the identity registered
+// with the app. It returns the user's organizations.
+func admitLogin(app *App, identity, invite, orgInvite string) ([]orgChoice, error) {
+	if err := admitToApp(app, identity, invite, true); err != nil {
+		return nil, err
+	}
+	redeemOrgInvite(orgInvite, identity)
+	if app != nil {
+		registerIdentity(app, identity)
+	}
+	return orgsFor(identity), nil
+}
+
+// setOrgs picks the organization of a single membership, or leaves the
+// choice to the user.
+func (c *Code) setOrgs(orgs []orgChoice) {
+	switch len(orgs) {
+	case 0:
+	case 1:
+		c.Org = &orgs[0]
+	default:
+		c.Orgs = orgs
+	}
+}
+
+// loginRedirect finishes a browser-redirect login (google, telegram widget):
+// sends the user back to the app with a code, or to the org picker first.
+func loginRedirect(w http.ResponseWriter, r *http.Request, l *login) {
+	if l.Redirect == "" {
+		redeemOrgInvite(l.OrgInvite, identityKey(l.Method, l.User["id"]))
+		target := directRedirect
+		if target == "" {
+			target = "/"
+		}
+		http.Redirect(w, r, target, http.StatusFound)
+		return
+	}
+
+	oneTimeCode, err := issueCode(l)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if approvalPending(oneTimeCode) {
+		q := url.Values{"redirect": {l.Redirect}, "approve_code": {oneTimeCode}}
+		http.Redirect(w, r, "/?"+q.Encode(), http.StatusFound)
+		return
+	}
+	if pendingOrgs(oneTimeCode) != nil {
+		q := url.Values{"redirect": {l.Redirect}, "org_code": {oneTimeCode}}
+		http.Redirect(w, r, "/?"+q.Encode(), http.StatusFound)
+		return
+	}
+	sep := "?"
+	if strings.Contains(l.Redirect, "?") {
+		sep = "&"
+	}
+	http.Redirect(w, r, l.Redirect+sep+"code="+oneTimeCode, http.StatusFound)
 }
 
 // ── template ──────────────────────────────────────────────────────────────
@@ -181,13 +408,62 @@ func handleIndex(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	redirectURL := r.URL.Query().Get("redirect")
-	if redirectURL == "" && directRedirect != "" {
+	orgInvite := r.URL.Query().Get("org_invite")
+	if redirectURL == "" && orgInvite == "" && directRedirect != "" {
 		http.Redirect(w, r, directRedirect, http.StatusFound)
 		return
 	}
+
+	// back from google with several organizations to pick from
+	var orgPick any
+	if code := r.URL.Query().Get("org_code"); code != "" {
+		if orgs := pendingOrgs(code); orgs != nil {
+			orgPick = map[string]any{"code": code, "orgs": orgs}
+		}
+	}
+	// back from google, waiting for the telegram second factor
+	approveCode := ""
+	if code := r.URL.Query().Get("approve_code"); approvalPending(code) {
+		approveCode = code
+	}
+
 	rdJSON, _ := json.Marshal(redirectURL)
-	indexTmpl.Execute(w, struct{ RedirectURL template.JS }{ //nolint:errcheck
-		RedirectURL: template.JS(rdJSON),
+	invJSON, _ := json.Marshal(orgInvite)
+	pickJSON, _ := json.Marshal(orgPick)
+	approveJSON, _ := json.Marshal(approveCode)
+	app := appForRedirect(redirectURL)
+	// the widget gives no phone number, so apps that need one get QR only
+	botUsername := ""
+	if b := appBot(app); b != nil && !app.wants("phone") {
+		botUsername = b.Username
+	}
+	botJSON, _ := json.Marshal(botUsername)
+	lang := pageLang(r, app)
+	msgs := pageMsgs(lang)
+	langJSON, _ := json.Marshal(lang)
+	msgsJSON, _ := json.Marshal(msgs)
+	indexTmpl.Execute(w, struct { //nolint:errcheck
+		RedirectURL    template.JS
+		OrgInvite      template.JS
+		OrgPick        template.JS
+		ApproveCode    template.JS
+		BotUsername    template.JS
+		Lang           string
+		LangJS         template.JS
+		T              map[string]string
+		TJS            template.JS
+		InviteRequired bool
+	}{
+		RedirectURL:    template.JS(rdJSON),
+		OrgInvite:      template.JS(invJSON),
+		OrgPick:        template.JS(pickJSON),
+		ApproveCode:    template.JS(approveJSON),
+		BotUsername:    template.JS(botJSON),
+		Lang:           lang,
+		LangJS:         template.JS(langJSON),
+		T:              msgs,
+		TJS:            template.JS(msgsJSON),
+		InviteRequired: inviteRequired(redirectURL),
 	})
 }
 
@@ -195,9 +471,16 @@ func handleIndex(w http.ResponseWriter, r *http.Request) {
 func handleQRSession(w http.ResponseWriter, r *http.Request) {
 	cleanSessions()
 	var body struct {
-		Redirect string `json:"redirect"`
+		Redirect  string `json:"redirect"`
+		Invite    string `json:"invite"`
+		OrgInvite string `json:"org_invite"`
 	}
 	json.NewDecoder(r.Body).Decode(&body) //nolint:errcheck
+	b := appBot(appForRedirect(body.Redirect))
+	if b == nil {
+		jsonErr(w, "telegram login is not configured", http.StatusServiceUnavailable)
+		return
+	}
 
 	tok := randToken(32)
 	sessionsMu.Lock()
@@ -205,10 +488,16 @@ func handleQRSession(w http.ResponseWriter, r *http.Request) {
 		Status:    "pending",
 		CreatedAt: time.Now(),
 		Redirect:  body.Redirect,
+		IP:        clientIP(r),
+		UserAgent: r.UserAgent(),
+		Invite:    body.Invite,
+		OrgInvite: body.OrgInvite,
+		PublicURL: publicURL(r),
+		Bot:       b,
 	}
 	sessionsMu.Unlock()
 
-	tmeURL := fmt.Sprintf("https://t.me/%s?start=%s", botUsername, tok)
+	tmeURL := fmt.Sprintf("https://t.me/%s?start=%s", b.Username, tok)
 	qr, err := makeQR(tmeURL)
 	if err != nil {
 		jsonErr(w, "qr error", http.StatusInternalServerError)
@@ -235,75 +524,25 @@ func handlePoll(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	resp := map[string]any{"status": sess.Status, "user": sess.User}
+	if sess.Error != "" {
+		resp["error"] = sess.Error
+	}
+	if sess.Status == "scanned" {
+		resp["number"] = sess.Number
+	}
+	code := ""
 	if sess.Status == "authenticated" && sess.Redirect != "" {
-		resp["code"] = sess.Code
+		code = sess.Code
+		resp["code"] = code
 		resp["redirect"] = sess.Redirect
 	}
 	sessionsMu.Unlock()
 
-	jsonOK(w, resp)
-}
-
-// POST /webhook
-func handleWebhook(w http.ResponseWriter, r *http.Request) {
-	if webhookSecret != "" {
-		if r.Header.Get("X-Telegram-Bot-Api-Secret-Token") != webhookSecret {
-			w.WriteHeader(http.StatusForbidden)
-			return
-		}
-	}
-
-	var update struct {
-		Message struct {
-			Text string `json:"text"`
-			From struct {
-				ID        int64  `json:"id"`
-				FirstName string `json:"first_name"`
-				LastName  string `json:"last_name"`
-				Username  string `json:"username"`
-			} `json:"from"`
-		} `json:"message"`
-	}
-	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		return
-	}
-
-	text := update.Message.Text
-	from := update.Message.From
-
-	if strings.HasPrefix(text, "/start ") {
-		tok := strings.TrimSpace(strings.TrimPrefix(text, "/start "))
-
-		sessionsMu.Lock()
-		sess, ok := sessions[tok]
-		if ok && sess.Status == "pending" && time.Since(sess.CreatedAt) <= sessionTTL {
-			user := map[string]any{
-				"id":         from.ID,
-				"first_name": from.FirstName,
-				"last_name":  from.LastName,
-				"username":   from.Username,
-			}
-			sess.Status = "authenticated"
-			sess.User = user
-			if sess.Redirect != "" {
-				sess.Code = newCode(user, "telegram")
-			}
-			sessionsMu.Unlock()
-			go sendTG(from.ID, "You are authenticated!")
-		} else {
-			expired := ok && time.Since(sess.CreatedAt) > sessionTTL
-			if expired {
-				delete(sessions, tok)
-			}
-			sessionsMu.Unlock()
-			if ok {
-				go sendTG(from.ID, "This QR code has expired.")
-			}
-		}
+	if orgs := pendingOrgs(code); orgs != nil {
+		resp["orgs"] = orgs
 	}
 
-	w.WriteHeader(http.StatusOK)
+	jsonOK(w, resp)
 }
 
 // POST /solana/nonce
@@ -324,6 +563,8 @@ func handleSolanaAuth(w http.ResponseWriter, r *http.Request) {
 		Nonce       string `json:"nonce"`
 		NonceToken  string `json:"nonce_token"`
 		Redirect    string `json:"redirect"`
+		Invite      string `json:"invite"`
+		OrgInvite   string `json:"org_invite"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
 		jsonErr(w, "no data", http.StatusBadRequest)
@@ -360,11 +601,37 @@ func handleSolanaAuth(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if isSuspended(identityKey("solana", body.PublicKey)) {
+		jsonErr(w, "account suspended", http.StatusForbidden)
+		return
+	}
+
 	resp := map[string]any{"ok": true, "public_key": body.PublicKey}
 	if body.Redirect != "" {
 		user := map[string]any{"id": body.PublicKey}
-		resp["code"] = newCode(user, "solana")
+		code, err := issueCode(&login{
+			Method:    "solana",
+			User:      user,
+			Redirect:  body.Redirect,
+			IP:        clientIP(r),
+			UserAgent: r.UserAgent(),
+			Invite:    body.Invite,
+			OrgInvite: body.OrgInvite,
+		})
+		if err != nil {
+			jsonErr(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		resp["code"] = code
 		resp["redirect"] = body.Redirect
+		if orgs := pendingOrgs(code); orgs != nil {
+			resp["orgs"] = orgs
+		}
+		if approvalPending(code) {
+			resp["approval"] = "telegram"
+		}
+	} else {
+		redeemOrgInvite(body.OrgInvite, identityKey("solana", body.PublicKey))
 	}
 	jsonOK(w, resp)
 }
@@ -380,7 +647,8 @@ func handleExchange(w http.ResponseWriter, r *http.Request) {
 		jsonErr(w, "no data", http.StatusBadRequest)
 		return
 	}
-	if len(appTokens) > 0 && !appTokens[body.AppToken] {
+	app := appByToken(body.AppToken)
+	if (len(appTokens) > 0 || len(apps) > 0) && !appTokens[body.AppToken] && app == nil {
 		jsonErr(w, "unauthorized", http.StatusForbidden)
 		return
 	}
@@ -389,8 +657,26 @@ func handleExchange(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	appID := ""
+	if app != nil {
+		appID = app.ID
+	}
+
 	codesMu.Lock()
 	entry, ok := codes[body.Code]
+	// a code is redeemed by the app it was issued for: a registered app
+	// can't take a code from an unregistered redirect, which skipped its
+	// rules, invites and second factor
+	if ok && entry.App != appID {
+		codesMu.Unlock()
+		jsonErr(w, "invalid or expired code", http.StatusForbidden)
+		return
+	}
+	if ok && entry.Approval != "" {
+		codesMu.Unlock()
+		jsonErr(w, "telegram approval pending", http.StatusForbidden)
+		return
+	}
 	if !ok || time.Since(entry.CreatedAt) > codeTTL {
 		if ok {
 			delete(codes, body.Code)
@@ -401,10 +687,37 @@ func handleExchange(w http.ResponseWriter, r *http.Request) {
 	}
 	user := entry.User
 	method := entry.Method
+	claims := entry.Claims
+	org := entry.Org
+	loginID := entry.Login
+	amr := entry.AMR
 	delete(codes, body.Code)
 	codesMu.Unlock()
 
-	jsonOK(w, map[string]any{"ok": true, "user": user, "method": method})
+	if isSuspended(identityKey(method, user["id"])) {
+		jsonErr(w, "account suspended", http.StatusForbidden)
+		return
+	}
+
+	grant := grantsFor(identityKey(method, user["id"]), appID)
+
+	resp := map[string]any{"ok": true, "user": user, "method": method, "session_id": loginID}
+	if claims != nil {
+		resp["claims"] = claims
+	}
+	if len(grant.Roles) > 0 {
+		resp["roles"] = grant.Roles
+	}
+	if len(grant.Groups) > 0 {
+		resp["groups"] = grant.Groups
+	}
+	if org != nil {
+		resp["org"] = org
+	}
+	if amr != nil {
+		resp["amr"] = amr
+	}
+	jsonOK(w, resp)
 }
 
 // ── google oauth ──────────────────────────────────────────────────────────
@@ -414,7 +727,12 @@ func handleGoogleLogin(w http.ResponseWriter, r *http.Request) {
 	redirectURL := r.URL.Query().Get("redirect")
 	state := randToken(32)
 	googleStatesMu.Lock()
-	googleStates[state] = googleState{Redirect: redirectURL, CreatedAt: time.Now()}
+	googleStates[state] = googleState{
+		Redirect:  redirectURL,
+		Invite:    r.URL.Query().Get("invite"),
+		OrgInvite: r.URL.Query().Get("org_invite"),
+		CreatedAt: time.Now(),
+	}
 	googleStatesMu.Unlock()
 
 	params := url.Values{
@@ -424,7 +742,7 @@ func handleGoogleLogin(w http.ResponseWriter, r *http.Request) {
 		"scope":         {"openid email profile"},
 		"state":         {state},
 	}
-	http.Redirect(w, r, "https://accounts.google.com/o/oauth2/v2/auth?"+params.Encode(), http.StatusFound)
+	http.Redirect(w, r, googleAuthAPI+"/o/oauth2/v2/auth?"+params.Encode(), http.StatusFound)
 }
 
 // GET /google/callback
@@ -458,7 +776,7 @@ func handleGoogleCallback(w http.ResponseWriter, r *http.Request) {
 		"grant_type":    "authorization_code",
 	})
 	tokenResp, err := httpClient.Post(
-		"https://oauth2.googleapis.com/token",
+		googleTokenAPI+"/token",
 		"application/json",
 		bytes.NewReader(tokenBody),
 	)
@@ -477,7 +795,7 @@ func handleGoogleCallback(w http.ResponseWriter, r *http.Request) {
 	accessToken, _ := tokenData["access_token"].(string)
 
 	// fetch user info
-	req, _ := http.NewRequest("GET", "https://www.googleapis.com/oauth2/v3/userinfo", nil)
+	req, _ := http.NewRequest("GET", googleUserAPI+"/oauth2/v3/userinfo", nil)
 	req.Header.Set("Authorization", "Bearer "+accessToken)
 	userResp, err := httpClient.Do(req)
 	if err != nil {
@@ -493,21 +811,20 @@ func handleGoogleCallback(w http.ResponseWriter, r *http.Request) {
 	name, _ := userInfo["name"].(string)
 	user := map[string]any{"id": sub, "email": email, "name": name}
 
-	if stateData.Redirect != "" {
-		oneTimeCode := newCode(user, "google")
-		sep := "?"
-		if strings.Contains(stateData.Redirect, "?") {
-			sep = "&"
-		}
-		http.Redirect(w, r, stateData.Redirect+sep+"code="+oneTimeCode, http.StatusFound)
+	if isSuspended(identityKey("google", sub)) {
+		http.Error(w, "account suspended", http.StatusForbidden)
 		return
 	}
 
-	target := directRedirect
-	if target == "" {
-		target = "/"
-	}
-	http.Redirect(w, r, target, http.StatusFound)
+	loginRedirect(w, r, &login{
+		Method:    "google",
+		User:      user,
+		Redirect:  stateData.Redirect,
+		IP:        clientIP(r),
+		UserAgent: r.UserAgent(),
+		Invite:    stateData.Invite,
+		OrgInvite: stateData.OrgInvite,
+	})
 }
 
 // ── main ──────────────────────────────────────────────────────────────────
@@ -515,10 +832,50 @@ func handleGoogleCallback(w http.ResponseWriter, r *http.Request) {
 func main() {
 	godotenv.Load() //nolint:errcheck
 
-	botToken = os.Getenv("BOT_TOKEN")
-	botUsername = os.Getenv("BOT_USERNAME")
-	webhookSecret = os.Getenv("WEBHOOK_SECRET")
+	if token := os.Getenv("BOT_TOKEN"); token != "" {
+		defaultBot = &Bot{
+			Token:       token,
+			Username:    os.Getenv("BOT_USERNAME"),
+			Secret:      os.Getenv("WEBHOOK_SECRET"),
+			WebhookURL:  os.Getenv("WEBHOOK_URL"),
+			Description: os.Getenv("BOT_DESCRIPTION"),
+		}
+	}
+	botMode = os.Getenv("BOT_MODE")
+	webhookDropPending = os.Getenv("WEBHOOK_DROP_PENDING") == "true"
 	directRedirect = os.Getenv("DIRECT_REDIRECT")
+	adminToken = os.Getenv("ADMIN_TOKEN")
+	if key := os.Getenv("WEBAPP_PUBLIC_KEY"); key != "" {
+		b, err := hex.DecodeString(key)
+		if err != nil || len(b) != ed25519.PublicKeySize {
+			log.Fatalf("WEBAPP_PUBLIC_KEY: not a hex ed25519 key")
+		}
+		webAppPublicKey = ed25519.PublicKey(b)
+	}
+
+	var err error
+	if trustedProxies, err = parseProxies(os.Getenv("TRUSTED_PROXIES")); err != nil {
+		log.Fatalf("TRUSTED_PROXIES: %v", err)
+	}
+	if telegramProxy, err = parseProxy(os.Getenv("TELEGRAM_PROXY")); err != nil {
+		log.Fatalf("TELEGRAM_PROXY: %v", err)
+	}
+	if googleProxy, err = parseProxy(os.Getenv("GOOGLE_PROXY")); err != nil {
+		log.Fatalf("GOOGLE_PROXY: %v", err)
+	}
+	for _, u := range []struct {
+		env string
+		dst *string
+	}{
+		{"TELEGRAM_API_URL", &telegramAPI},
+		{"GOOGLE_AUTH_URL", &googleAuthAPI},
+		{"GOOGLE_TOKEN_URL", &googleTokenAPI},
+		{"GOOGLE_API_URL", &googleUserAPI},
+	} {
+		if *u.dst, err = baseURL(os.Getenv(u.env), *u.dst); err != nil {
+			log.Fatalf("%s: %v", u.env, err)
+		}
+	}
 
 	googleClientID = os.Getenv("GOOGLE_CLIENT_ID")
 	googleClientSecret = os.Getenv("GOOGLE_CLIENT_SECRET")
@@ -534,6 +891,30 @@ func main() {
 		}
 	}
 
+	if path := os.Getenv("DATA_FILE"); path != "" {
+		if err := loadStore(path); err != nil {
+			log.Fatalf("data file: %v", err)
+		}
+	}
+
+	initRules()
+	if path := os.Getenv("APPS_FILE"); path != "" {
+		if err := loadApps(path); err != nil {
+			log.Fatalf("apps file: %v", err)
+		}
+	}
+
+	if botMode == "polling" {
+		for _, b := range allBots() {
+			if err := checkNoWebhook(b); err != nil {
+				log.Fatalf("polling mode, bot %s: %v", botName(b), err)
+			}
+		}
+	}
+	if len(allBots()) > 0 {
+		startTGWorkers()
+	}
+
 	initTemplate()
 
 	webFS, _ := fs.Sub(webFiles, "web")
@@ -543,20 +924,90 @@ func main() {
 	mux.HandleFunc("GET /", handleIndex)
 	mux.HandleFunc("POST /qr-session", handleQRSession)
 	mux.HandleFunc("GET /poll/{token}", handlePoll)
-	mux.HandleFunc("POST /webhook", handleWebhook)
+	if botMode == "polling" {
+		for _, b := range allBots() {
+			go pollUpdates(b)
+		}
+	} else {
+		mux.HandleFunc("POST /webhook", handleWebhook)
+		mux.HandleFunc("POST /webhook/{bot}", handleWebhook)
+	}
+	mux.HandleFunc("GET /telegram/widget", handleTelegramWidget)
+	mux.HandleFunc("GET /telegram/avatar/{id}/{sig}", handleTelegramAvatar)
+	mux.HandleFunc("POST /telegram/webapp", handleTelegramWebApp)
+	mux.HandleFunc("OPTIONS /telegram/webapp", handleTelegramWebApp)
 	mux.HandleFunc("POST /solana/nonce", handleSolanaNonce)
 	mux.HandleFunc("POST /solana/auth", handleSolanaAuth)
 	mux.HandleFunc("GET /google/login", handleGoogleLogin)
 	mux.HandleFunc("GET /google/callback", handleGoogleCallback)
 	mux.HandleFunc("POST /exchange", handleExchange)
+	mux.HandleFunc("POST /session/check", handleSessionCheck)
+	mux.HandleFunc("POST /bc/authorize", handleBCAuthorize)
+	mux.HandleFunc("POST /bc/token", handleBCToken)
+	mux.HandleFunc("POST /notify", handleNotify)
+	mux.HandleFunc("GET /status", handleStatus)
+	mux.HandleFunc("GET /metrics", handleMetrics)
+	mux.HandleFunc("POST /org/select", handleOrgSelect)
+	mux.HandleFunc("GET /approval/{code}", handleApproval)
+	mux.HandleFunc("GET /invite/{code}", handleOrgInviteLink)
+	mux.HandleFunc("GET /admin/users/{identity}", requireAdmin(handleAdminGetUser))
+	mux.HandleFunc("PUT /admin/users/{identity}", requireAdmin(handleAdminPutUser))
+	mux.HandleFunc("DELETE /admin/users/{identity}", requireAdmin(handleAdminDeleteUser))
+	mux.HandleFunc("GET /admin/accounts/{account}", requireAdmin(handleAdminGetAccount))
+	mux.HandleFunc("PUT /admin/accounts/{account}", requireAdmin(handleAdminPutAccount))
+	mux.HandleFunc("DELETE /admin/accounts/{account}", requireAdmin(handleAdminDeleteAccount))
+	mux.HandleFunc("GET /admin/suspensions", requireAdmin(handleAdminListSuspensions))
+	mux.HandleFunc("PUT /admin/suspensions/{identity}", requireAdmin(handleAdminSuspend))
+	mux.HandleFunc("DELETE /admin/suspensions/{identity}", requireAdmin(handleAdminUnsuspend))
+	mux.HandleFunc("GET /admin/flagged", requireAdmin(handleAdminListFlagged))
+	mux.HandleFunc("DELETE /admin/flagged/{identity}", requireAdmin(handleAdminClearFlag))
+	mux.HandleFunc("GET /admin/orgs/{org}", requireAdmin(handleAdminGetOrg))
+	mux.HandleFunc("PUT /admin/orgs/{org}", requireAdmin(handleAdminPutOrg))
+	mux.HandleFunc("DELETE /admin/orgs/{org}", requireAdmin(handleAdminDeleteOrg))
+	mux.HandleFunc("PUT /admin/orgs/{org}/members/{identity}", requireAdmin(handleAdminPutMember))
+	mux.HandleFunc("DELETE /admin/orgs/{org}/members/{identity}", requireAdmin(handleAdminDeleteMember))
+	mux.HandleFunc("POST /admin/orgs/{org}/invites", requireAdmin(handleAdminCreateOrgInvite))
+	mux.HandleFunc("GET /admin/apps/{app}/invites", requireAdmin(handleAdminListAppInvites))
+	mux.HandleFunc("POST /admin/apps/{app}/invites", requireAdmin(handleAdminCreateAppInvite))
+	mux.HandleFunc("DELETE /admin/apps/{app}/invites/{code}", requireAdmin(handleAdminDeleteAppInvite))
 	mux.Handle("GET /style.css", fileServer)
 	mux.Handle("GET /script.js", fileServer)
 	mux.Handle("GET /favicon.svg", fileServer)
 
+	if len(allBots()) > 0 {
+		go setupBots()
+		go runOutbox()
+	}
+	go runStoreFlush()
+
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8886"
 	}
-	log.Printf("listening on :%s", port)
-	log.Fatal(http.ListenAndServe(":"+port, mux))
+	srv := &http.Server{Addr: ":" + port, Handler: mux}
+	go func() {
+		log.Printf("listening on :%s", port)
+		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	<-stop
+	shutdown(srv)
+}
+
+// shutdown lets in-flight requests finish, gives queued Telegram calls a
+// chance to go out and writes pending state to DATA_FILE.
+func shutdown(srv *http.Server) {
+	log.Printf("shutting down")
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("shutdown: %v", err)
+	}
+	deadline, _ := ctx.Deadline()
+	drainTG(time.Until(deadline))
+	flushStore()
 }
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// ── notifications ─────────────────────────────────────────────────────────
+//
+// A registered app sends a message to one of its users through the bot:
+// POST /notify with its token, the user's identity, a template name from
+// the app's "templates" and the template's params. Only users who have
+// logged in to the app and reach Telegram (directly or through a linked
+// identity) can be messaged; each message has a button to mute the app.
+//
+// Messages go to an outbox kept in DATA_FILE, so a restart or a Telegram
+// outage delays them instead of losing them. Like logins, the outbox is
+// written by the background save rather than on every change.
+
+const (
+	defaultNotifyRate = 20 // messages per minute per app
+	outboxMaxAttempts = 8
+	outboxMaxBackoff  = 10 * time.Minute
+)
+
+type OutMessage struct {
+	App       string    `json:"app"`
+	ChatID    int64     `json:"chat_id"`
+	Text      string    `json:"text"`
+	Attempts  int       `json:"attempts"`
+	NextAt    time.Time `json:"next_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type rateBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+var (
+	notifyBuckets = make(map[string]*rateBucket)
+	rateBucketsMu sync.Mutex
+
+	outboxWake   = make(chan struct{}, 1)
+	outboxClient = &http.Client{Timeout: 10 * time.Second, Transport: telegramTransport}
+)
+
+// compileTemplates parses the app's message templates.
+func compileTemplates(app *App) (map[string]*template.Template, error) {
+	out := make(map[string]*template.Template)
+	for name, src := range app.Templates {
+		t, err := template.New(name).Option("missingkey=error").Parse(src)
+		if err != nil {
+			return nil, err
+		}
+		out[name] = t
+	}
+	return out, nil
+}
+
+// allowNotify takes one message from the app's per-minute budget.
+func allowNotify(app *App) bool {
+	rate := float64(app.NotifyRate)
+	if rate <= 0 {
+		rate = defaultNotifyRate
+	}
+	return takeRate(notifyBuckets, app.ID, rate)
+}
+
+// takeRate takes one token from the bucket of key, refilled at rate per
+// minute.
+func takeRate(buckets map[string]*rateBucket, key string, rate float64) bool {
+	rateBucketsMu.Lock()
+	defer rateBucketsMu.Unlock()
+	b, ok := buckets[key]
+	if !ok {
+		b = &rateBucket{tokens: rate, last: time.Now()}
+		buckets[key] = b
+	}
+	b.tokens = min(rate, b.tokens+time.Since(b.last).Minutes()*rate)
+	b.last = time.Now()
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// registeredWith reports whether identity, or an identity linked to it,
+// has logged in to app.
+func registeredWith(app *App, identity string) bool {
+	storeMu.Lock()
+	defer storeMu.Unlock()
+	for _, id := range linkedIdentities(identity) {
+		if _, ok := store.Registered[app.ID][id]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// POST /notify
+func handleNotify(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		AppToken string         `json:"app_token"`
+		Identity string         `json:"identity"`
+		Template string         `json:"template"`
+		Params   map[string]any `json:"params"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		jsonErr(w, "no data", http.StatusBadRequest)
+		return
+	}
+	app := appByToken(body.AppToken)
+	if app == nil {
+		jsonErr(w, "unauthorized", http.StatusForbidden)
+		return
+	}
+	tmpl, ok := app.templates[body.Template]
+	if !ok {
+		jsonErr(w, "unknown template", http.StatusBadRequest)
+		return
+	}
+	var text strings.Builder
+	if err := tmpl.Execute(&text, body.Params); err != nil {
+		jsonErr(w, "template: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !registeredWith(app, body.Identity) {
+		jsonErr(w, "user never logged in to this app", http.StatusNotFound)
+		return
+	}
+	chatID, ok := telegramID(body.Identity)
+	if !ok {
+		jsonErr(w, "user has no telegram", http.StatusNotFound)
+		return
+	}
+	if isSuspended(body.Identity) {
+		jsonErr(w, "account suspended", http.StatusForbidden)
+		return
+	}
+	if appBot(app) == nil {
+		jsonErr(w, "telegram bot is not configured", http.StatusServiceUnavailable)
+		return
+	}
+	if !allowNotify(app) {
+		w.Header().Set("Retry-After", "60")
+		jsonErr(w, "rate limit exceeded", http.StatusTooManyRequests)
+		return
+	}
+
+	id := randToken(12)
+	storeMu.Lock()
+	if slices.Contains(store.OptOut[identityKey("telegram", chatID)], app.ID) {
+		storeMu.Unlock()
+		jsonErr(w, "user opted out", http.StatusForbidden)
+		return
+	}
+	store.Outbox[id] = &OutMessage{
+		App:       app.ID,
+		ChatID:    chatID,
+		Text:      fmt.Sprintf("%s:\n\n%s", appName(app), text.String()),
+		NextAt:    time.Now(),
+		CreatedAt: time.Now(),
+	}
+	storeDirty = true
+	storeMu.Unlock()
+	select {
+	case outboxWake <- struct{}{}:
+	default:
+	}
+	jsonOK(w, map[string]any{"ok": true, "id": id})
+}
+
+// ── notifications: outbox ─────────────────────────────────────────────────
+
+// runOutbox delivers queued notifications, retrying with backoff.
+func runOutbox() {
+	tick := time.NewTicker(5 * time.Second)
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+		case <-outboxWake:
+		}
+		for _, id := range dueMessages() {
+			deliver(id)
+		}
+	}
+}
+
+func dueMessages() []string {
+	storeMu.Lock()
+	defer storeMu.Unlock()
+	var due []string
+	for id, m := range store.Outbox {
+		if !time.Now().Before(m.NextAt) {
+			due = append(due, id)
+		}
+	}
+	return due
+}
+
+func deliver(id string) {
+	storeMu.Lock()
+	m, ok := store.Outbox[id]
+	var msg OutMessage
+	if ok {
+		msg = *m
+		// muted while queued
+		if slices.Contains(store.OptOut[identityKey("telegram", m.ChatID)], m.App) {
+			delete(store.Outbox, id)
+			ok = false
+			storeDirty = true
+		}
+	}
+	storeMu.Unlock()
+	if !ok {
+		return
+	}
+
+	lang := appLang(apps[msg.App])
+	err := tgRequest(appBot(apps[msg.App]), outboxClient, "sendMessage", map[string]any{
+		"chat_id": msg.ChatID,
+		"text":    msg.Text,
+		"reply_markup": map[string]any{
+			"inline_keyboard": [][]map[string]string{
+				{{"text": botMsg(lang, "mute", appLabelByID(msg.App)), "callback_data": "optout:" + msg.App}},
+			},
+		},
+	}, nil)
+
+	storeMu.Lock()
+	defer storeMu.Unlock()
+	delay, retry := tgRetryDelay(err, m.Attempts)
+	switch {
+	case err == nil:
+		delete(store.Outbox, id)
+	case !retry || m.Attempts+1 >= outboxMaxAttempts:
+		log.Printf("notify %s: giving up: %v", id, err)
+		delete(store.Outbox, id)
+	default:
+		m.Attempts++
+		m.NextAt = time.Now().Add(min(delay, outboxMaxBackoff))
+		log.Printf("notify %s: attempt %d: %v", id, m.Attempts, err)
+	}
+	storeDirty = true
+}
+
+// appLabelByID names an app id for buttons; unknown ids stay as they are.
+func appLabelByID(id string) string {
+	if app, ok := apps[id]; ok {
+		return appName(app)
+	}
+	return id
+}
+
+// ── notifications: opt-out ────────────────────────────────────────────────
+
+// handleOptCallback mutes ("optout") or unmutes ("optin") an app.
+func handleOptCallback(b *Bot, cq *tgCallbackQuery, action, appID string) {
+	me := identityKey("telegram", cq.From.ID)
+	storeMu.Lock()
+	muted := slices.DeleteFunc(store.OptOut[me], func(a string) bool { return a == appID })
+	if action == "optout" {
+		muted = append(muted, appID)
+	}
+	if len(muted) == 0 {
+		delete(store.OptOut, me)
+	} else {
+		store.OptOut[me] = muted
+	}
+	if err := saveStore(); err != nil {
+		log.Printf("save store: %v", err)
+	}
+	storeMu.Unlock()
+
+	lang := userLang(cq.From, nil)
+	text := botMsg(lang, "unmuted", appLabelByID(appID))
+	if action == "optout" {
+		text = botMsg(lang, "muted", appLabelByID(appID))
+	}
+	answerCallback(b, cq, "")
+	sendTG(b, cq.From.ID, text)
+}
+
+// handleNotifications answers /notifications with the muted apps.
+func handleNotifications(b *Bot, from tgUser) {
+	storeMu.Lock()
+	muted := slices.Clone(store.OptOut[identityKey("telegram", from.ID)])
+	storeMu.Unlock()
+	lang := userLang(from, nil)
+	if len(muted) == 0 {
+		sendTG(b, from.ID, botMsg(lang, "no_muted"))
+		return
+	}
+	var keyboard [][]map[string]string
+	for _, id := range muted {
+		keyboard = append(keyboard, []map[string]string{{
+			"text": botMsg(lang, "unmute", appLabelByID(id)), "callback_data": "optin:" + id,
+		}})
+	}
+	tgCall(b, "sendMessage", map[string]any{
+		"chat_id":      from.ID,
+		"text":         botMsg(lang, "muted_apps"),
+		"reply_markup": map[string]any{"inline_keyboard": keyboard},
+	})
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"time"
+)
+
+// ── organizations ─────────────────────────────────────────────────────────
+//
+// An organization has members (identities) with a membership role. A user
+// joins by redeeming an invite link after logging in with any method. The
+// exchanged identity carries the organization picked for this login; a
+// member of several organizations chooses one on the login page.
+
+type Org struct {
+	Name    string            `json:"name"`
+	Members map[string]string `json:"members"` // identity → role
+}
+
+type OrgInvite struct {
+	Org       string     `json:"org"`
+	Role      string     `json:"role"`
+	Redirect  string     `json:"redirect,omitempty"`
+	MaxUses   int        `json:"max_uses,omitempty"`
+	Uses      int        `json:"uses"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// orgChoice is one membership as shown to the user and to the app.
+type orgChoice struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Role string `json:"role"`
+}
+
+func (inv *OrgInvite) valid() bool {
+	if inv.ExpiresAt != nil && time.Now().After(*inv.ExpiresAt) {
+		return false
+	}
+	return inv.MaxUses == 0 || inv.Uses < inv.MaxUses
+}
+
+// redeemOrgInvite makes identity a member of the invite's organization.
+// It runs on every successful login, with or without a redirect. Invalid
+// or unknown codes are ignored — the login itself still succeeds.
+func redeemOrgInvite(code, identity string) {
+	if code == "" {
+		return
+	}
+	storeMu.Lock()
+	defer storeMu.Unlock()
+	inv, ok := store.OrgInvites[code]
+	if !ok || !inv.valid() {
+		return
+	}
+	org, ok := store.Orgs[inv.Org]
+	if !ok {
+		return
+	}
+	if _, member := org.Members[identity]; !member {
+		org.Members[identity] = inv.Role
+		inv.Uses++
+	}
+	if err := saveStore(); err != nil {
+		log.Printf("save store: %v", err)
+	}
+}
+
+// orgsFor lists the organizations identity (or a linked identity) is in.
+func orgsFor(identity string) []orgChoice {
+	storeMu.Lock()
+	defer storeMu.Unlock()
+	ids := linkedIdentities(identity)
+	var out []orgChoice
+	for id, org := range store.Orgs {
+		for _, member := range ids {
+			if role, ok := org.Members[member]; ok {
+				out = append(out, orgChoice{ID: id, Name: org.Name, Role: role})
+				break
+			}
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// pendingOrgs returns the choices still waiting for the user to pick one,
+// or nil when code needs no picker.
+func pendingOrgs(code string) []orgChoice {
+	codesMu.Lock()
+	defer codesMu.Unlock()
+	if c, ok := codes[code]; ok && c.Org == nil {
+		return c.Orgs
+	}
+	return nil
+}
+
+// POST /org/select
+func handleOrgSelect(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Code string `json:"code"`
+		Org  string `json:"org"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		jsonErr(w, "no data", http.StatusBadRequest)
+		return
+	}
+	codesMu.Lock()
+	defer codesMu.Unlock()
+	c, ok := codes[body.Code]
+	if !ok || c.Org != nil {
+		jsonErr(w, "invalid or expired code", http.StatusForbidden)
+		return
+	}
+	i := slices.IndexFunc(c.Orgs, func(o orgChoice) bool { return o.ID == body.Org })
+	if i < 0 {
+		jsonErr(w, "not a member", http.StatusForbidden)
+		return
+	}
+	c.Org = &c.Orgs[i]
+	jsonOK(w, map[string]any{"ok": true})
+}
+
+// GET /invite/{code}
+func handleOrgInviteLink(w http.ResponseWriter, r *http.Request) {
+	code := r.PathValue("code")
+	storeMu.Lock()
+	inv, ok := store.OrgInvites[code]
+	valid := ok && inv.valid()
+	storeMu.Unlock()
+	if !valid {
+		http.Error(w, "invalid or expired invite", http.StatusNotFound)
+		return
+	}
+	q := url.Values{"org_invite": {code}}
+	if inv.Redirect != "" {
+		q.Set("redirect", inv.Redirect)
+	}
+	http.Redirect(w, r, "/?"+q.Encode(), http.StatusFound)
+}
+
+// ── organizations: admin ──────────────────────────────────────────────────
+
+// GET /admin/orgs/{org}
+func handleAdminGetOrg(w http.ResponseWriter, r *http.Request) {
+	storeMu.Lock()
+	defer storeMu.Unlock()
+	org, ok := store.Orgs[r.PathValue("org")]
+	if !ok {
+		jsonErr(w, "not found", http.StatusNotFound)
+		return
+	}
+	jsonOK(w, org)
+}
+
+// PUT /admin/orgs/{org}
+func handleAdminPutOrg(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Name == "" {
+		jsonErr(w, "name required", http.StatusBadRequest)
+		return
+	}
+	id := r.PathValue("org")
+	storeMu.Lock()
+	defer storeMu.Unlock()
+	org, ok := store.Orgs[id]
+	if !ok {
+		org = &Org{Members: make(map[string]string)}
+		store.Orgs[id] = org
+	}
+	org.Name = body.Name
+	if err := saveStore(); err != nil {
+		jsonErr(w, "save failed", http.StatusInternalServerError)
+		return
+	}
+	jsonOK(w, org)
+}
+
+// DELETE /admin/orgs/{org}
+func handleAdminDeleteOrg(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("org")
+	storeMu.Lock()
+	defer storeMu.Unlock()
+	delete(store.Orgs, id)
+	for code, inv := range store.OrgInvites {
+		if inv.Org == id {
+			delete(store.OrgInvites, code)
+		}
+	}
+	if err := saveStore(); err != nil {
+		jsonErr(w, "save failed", http.StatusInternalServerError)
+		return
+	}
+	jsonOK(w, map[string]any{"ok": true})
+}
+
+// PUT /admin/orgs/{org}/members/{identity}
+func handleAdminPutMember(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Role string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Role == "" {
+		jsonErr(w, "role required", http.StatusBadRequest)
+		return
+	}
+	storeMu.Lock()
+	defer storeMu.Unlock()
+	org, ok := store.Orgs[r.PathValue("org")]
+	if !ok {
+		jsonErr(w, "not found", http.StatusNotFound)
+		return
+	}
+	org.Members[r.PathValue("identity")] = body.Role
+	if err := saveStore(); err != nil {
+		jsonErr(w, "save failed", http.StatusInternalServerError)
+		return
+	}
+	jsonOK(w, org)
+}
+
+// DELETE /admin/orgs/{org}/members/{identity}
+func handleAdminDeleteMember(w http.ResponseWriter, r *http.Request) {
+	storeMu.Lock()
+	defer storeMu.Unlock()
+	org, ok := store.Orgs[r.PathValue("org")]
+	if !ok {
+		jsonErr(w, "not found", http.StatusNotFound)
+		return
+	}
+	delete(org.Members, r.PathValue("identity"))
+	if err := saveStore(); err != nil {
+		jsonErr(w, "save failed", http.StatusInternalServerError)
+		return
+	}
+	jsonOK(w, org)
+}
+
+// POST /admin/orgs/{org}/invites
+func handleAdminCreateOrgInvite(w http.ResponseWriter, r *http.Request) {
+	var inv OrgInvite
+	if err := json.NewDecoder(r.Body).Decode(&inv); err != nil {
+		jsonErr(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	if inv.Role == "" {
+		inv.Role = "member"
+	}
+	inv.Org = r.PathValue("org")
+	inv.Uses = 0
+	inv.CreatedAt = time.Now()
+	code := randToken(16)
+
+	storeMu.Lock()
+	defer storeMu.Unlock()
+	if _, ok := store.Orgs[inv.Org]; !ok {
+		jsonErr(w, "not found", http.StatusNotFound)
+		return
+	}
+	store.OrgInvites[code] = &inv
+	if err := saveStore(); err != nil {
+		jsonErr(w, "save failed", http.StatusInternalServerError)
+		return
+	}
+	jsonOK(w, map[string]any{"code": code, "url": publicURL(r) + "/invite/" + code, "invite": &inv})
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+)
+
+// ── telegram long polling ─────────────────────────────────────────────────
+//
+// BOT_MODE=polling replaces POST /webhook with a getUpdates loop, for hosts
+// Telegram cannot reach (staging, laptops, internal-only deployments).
+// Updates go through the same handleUpdate as the webhook; every bot
+// polls on its own.
+
+const (
+	updatesTimeout    = 50 // seconds Telegram holds a getUpdates call open
+	updatesMaxBackoff = time.Minute
+)
+
+var (
+	botMode string
+
+	updatesClient = &http.Client{Timeout: (updatesTimeout + 10) * time.Second, Transport: telegramTransport}
+)
+
+// checkNoWebhook refuses polling while a webhook is registered — Telegram
+// rejects getUpdates in that case and updates would go elsewhere anyway.
+func checkNoWebhook(b *Bot) error {
+	var info struct {
+		URL string `json:"url"`
+	}
+	if err := tgRequest(b, updatesClient, "getWebhookInfo", map[string]any{}, &info); err != nil {
+		return err
+	}
+	if info.URL != "" {
+		return errors.New("webhook is registered at " + info.URL + ", remove it with deleteWebhook first")
+	}
+	return nil
+}
+
+func pollUpdates(b *Bot) {
+	log.Printf("telegram %s: polling for updates", botName(b))
+	var offset int64
+	backoff := time.Second
+	for {
+		var updates []tgUpdate
+		err := tgRequest(b, updatesClient, "getUpdates", map[string]any{
+			"offset":          offset,
+			"timeout":         updatesTimeout,
+			"allowed_updates": allowedUpdates,
+		}, &updates)
+		if err != nil {
+			log.Printf("telegram %s: getUpdates: %v (retry in %s)", botName(b), err, backoff)
+			time.Sleep(backoff)
+			backoff = min(backoff*2, updatesMaxBackoff)
+			continue
+		}
+		backoff = time.Second
+
+		for i := range updates {
+			offset = updates[i].UpdateID + 1
+			handleUpdate(b, &updates[i])
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+)
+
+// ── roles and groups ──────────────────────────────────────────────────────
+//
+// Roles and groups are kept per identity, globally and per app. On
+// /exchange the app receives the global ones merged with its own, from
+// every identity of the user's account.
+
+type Grant struct {
+	Roles  []string `json:"roles,omitempty"`
+	Groups []string `json:"groups,omitempty"`
+}
+
+type UserGrants struct {
+	Grant
+	Apps map[string]Grant `json:"apps,omitempty"`
+}
+
+// grantsFor returns the roles and groups identity holds in app, merged
+// over every identity linked to it.
+func grantsFor(identity, app string) Grant {
+	storeMu.Lock()
+	defer storeMu.Unlock()
+	var g Grant
+	for _, id := range linkedIdentities(identity) {
+		u, ok := store.Users[id]
+		if !ok {
+			continue
+		}
+		g.Roles = mergeNames(g.Roles, u.Roles)
+		g.Groups = mergeNames(g.Groups, u.Groups)
+		if a, ok := u.Apps[app]; ok && app != "" {
+			g.Roles = mergeNames(g.Roles, a.Roles)
+			g.Groups = mergeNames(g.Groups, a.Groups)
+		}
+	}
+	return g
+}
+
+func mergeNames(a, b []string) []string {
+	for _, s := range b {
+		if !slices.Contains(a, s) {
+			a = append(a, s)
+		}
+	}
+	return a
+}
+
+// GET /admin/users/{identity}
+func handleAdminGetUser(w http.ResponseWriter, r *http.Request) {
+	storeMu.Lock()
+	u, ok := store.Users[r.PathValue("identity")]
+	storeMu.Unlock()
+	if !ok {
+		jsonErr(w, "not found", http.StatusNotFound)
+		return
+	}
+	jsonOK(w, u)
+}
+
+// PUT /admin/users/{identity}
+func handleAdminPutUser(w http.ResponseWriter, r *http.Request) {
+	var u UserGrants
+	if err := json.NewDecoder(r.Body).Decode(&u); err != nil {
+		jsonErr(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	storeMu.Lock()
+	store.Users[r.PathValue("identity")] = &u
+	err := saveStore()
+	storeMu.Unlock()
+	if err != nil {
+		jsonErr(w, "save failed", http.StatusInternalServerError)
+		return
+	}
+	jsonOK(w, &u)
+}
+
+// DELETE /admin/users/{identity}
+func handleAdminDeleteUser(w http.ResponseWriter, r *http.Request) {
+	storeMu.Lock()
+	delete(store.Users, r.PathValue("identity"))
+	err := saveStore()
+	storeMu.Unlock()
+	if err != nil {
+		jsonErr(w, "save failed", http.StatusInternalServerError)
+		return
+	}
+	jsonOK(w, map[string]any{"ok": true})
+}
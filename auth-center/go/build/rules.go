@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/google/cel-go/cel"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// ── authorization rules ───────────────────────────────────────────────────
+//
+// Every app may carry a CEL expression in "rule" and named CEL expressions
+// in "claims". Both are evaluated when a code is issued, against:
+//
+//	user     map     normalized user, same as returned by /exchange
+//	method   string  "telegram", "solana", "google", ...
+//	app      string  app id from APPS_FILE
+//	request  map     ip, user_agent, time (timestamp)
+//
+// The rule must return a bool; false (or an evaluation error) denies the
+// login. Each claim expression's result is added to the exchanged claims.
+//
+//	rule: "(method == 'telegram' && user.id in [1, 2]) ||
+//	       (method == 'google' && user.email.endsWith('@ourco.com') &&
+//	        request.time.getDayOfWeek('Europe/Moscow') in [1, 2, 3, 4, 5])"
+
+var errDenied = errors.New("access denied")
+
+type appRules struct {
+	rule   cel.Program
+	claims map[string]cel.Program
+}
+
+var (
+	celEnv        *cel.Env
+	jsonValueType = reflect.TypeOf(&structpb.Value{})
+)
+
+func initRules() {
+	var err error
+	celEnv, err = cel.NewEnv(
+		cel.Variable("user", cel.MapType(cel.StringType, cel.DynType)),
+		cel.Variable("method", cel.StringType),
+		cel.Variable("app", cel.StringType),
+		cel.Variable("request", cel.MapType(cel.StringType, cel.DynType)),
+	)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func compileExpr(src string, want *cel.Type) (cel.Program, error) {
+	ast, iss := celEnv.Compile(src)
+	if iss.Err() != nil {
+		return nil, iss.Err()
+	}
+	if want != nil && !ast.OutputType().IsEquivalentType(want) {
+		return nil, fmt.Errorf("expression must return %s, got %s", want, ast.OutputType())
+	}
+	return celEnv.Program(ast, cel.CostLimit(10000), cel.InterruptCheckFrequency(100))
+}
+
+func compileRules(app *App) (*appRules, error) {
+	rs := &appRules{claims: make(map[string]cel.Program)}
+	if app.Rule != "" {
+		prg, err := compileExpr(app.Rule, cel.BoolType)
+		if err != nil {
+			return nil, fmt.Errorf("rule: %w", err)
+		}
+		rs.rule = prg
+	}
+	for name, src := range app.Claims {
+		prg, err := compileExpr(src, nil)
+		if err != nil {
+			return nil, fmt.Errorf("claim %q: %w", name, err)
+		}
+		rs.claims[name] = prg
+	}
+	return rs, nil
+}
+
+// evalRules checks the app's rule for l and returns the extra claims.
+func evalRules(app *App, l *login) (map[string]any, error) {
+	if app == nil || app.rules == nil {
+		return nil, nil
+	}
+	vars := map[string]any{
+		"user":   l.User,
+		"method": l.Method,
+		"app":    app.ID,
+		"request": map[string]any{
+			"ip":         l.IP,
+			"user_agent": l.UserAgent,
+			"time":       time.Now(),
+		},
+	}
+
+	if app.rules.rule != nil {
+		out, _, err := app.rules.rule.Eval(vars)
+		if err != nil {
+			return nil, errDenied
+		}
+		if ok, _ := out.Value().(bool); !ok {
+			return nil, errDenied
+		}
+	}
+
+	var claims map[string]any
+	for name, prg := range app.rules.claims {
+		out, _, err := prg.Eval(vars)
+		if err != nil {
+			continue
+		}
+		v, err := out.ConvertToNative(jsonValueType)
+		if err != nil {
+			continue
+		}
+		if claims == nil {
+			claims = make(map[string]any)
+		}
+		claims[name] = v.(*structpb.Value).AsInterface()
+	}
+	return claims, nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"time"
+)
+
+// ── telegram second factor ────────────────────────────────────────────────
+//
+// An app with "telegram_2fa" holds Google and Solana codes of accounts
+// with a linked Telegram identity until the bot's Approve button is
+// pressed. The login page waits on GET /approval/{code}; /exchange refuses
+// the code meanwhile and afterwards reports both methods in "amr".
+
+const approvalTTL = 3 * time.Minute
+
+// holdForApproval marks entry pending and asks the user's Telegram to
+// approve it. It reports false when the account has no Telegram identity.
+func holdForApproval(entry *Code, l *login, identity string, app *App) bool {
+	if !app.SecondFactor || l.Method == "telegram" {
+		return false
+	}
+	b := appBot(app)
+	chatID, ok := telegramID(identity)
+	if !ok || b == nil {
+		return false
+	}
+	entry.Approval = "pending"
+
+	lang := appLang(app)
+	text := botMsg(lang, "approve_login", appName(app), l.Method, l.IP, l.UserAgent)
+	tgCall(b, "sendMessage", map[string]any{
+		"chat_id": chatID,
+		"text":    text,
+		"reply_markup": map[string]any{
+			"inline_keyboard": [][]map[string]string{{
+				{"text": botMsg(lang, "approve"), "callback_data": "mfa_ok:" + entry.Login},
+				{"text": botMsg(lang, "deny"), "callback_data": "mfa_no:" + entry.Login},
+			}},
+		},
+	})
+	return true
+}
+
+// handleApprovalCallback serves the Approve/Deny buttons.
+func handleApprovalCallback(b *Bot, cq *tgCallbackQuery, action, loginID string) {
+	lang := userLang(cq.From, nil)
+
+	storeMu.Lock()
+	mine := linkedIdentities(identityKey("telegram", cq.From.ID))
+	storeMu.Unlock()
+
+	codesMu.Lock()
+	var entry *Code
+	for _, c := range codes {
+		if c.Login == loginID && c.Approval == "pending" && !c.admitting {
+			entry = c
+			break
+		}
+	}
+	if entry == nil || time.Since(entry.CreatedAt) > approvalTTL ||
+		!slices.Contains(mine, identityKey(entry.Method, entry.User["id"])) {
+		codesMu.Unlock()
+		answerAndEdit(b, cq, botMsg(lang, "expired"))
+		return
+	}
+	if action != "mfa_ok" {
+		codesMu.Unlock()
+		revokeLogins([]string{loginID})
+		answerAndEdit(b, cq, botMsg(lang, "denied"))
+		return
+	}
+
+	// what the login grants is applied only now; admitting takes storeMu,
+	// which is never taken under codesMu
+	entry.admitting = true
+	app, identity := apps[entry.App], identityKey(entry.Method, entry.User["id"])
+	invite, orgInvite := entry.invite, entry.orgInvite
+	codesMu.Unlock()
+	orgs, err := admitLogin(app, identity, invite, orgInvite)
+	if err != nil {
+		revokeLogins([]string{loginID})
+		answerAndEdit(b, cq, botMsg(lang, "denied_reason", err.Error()))
+		return
+	}
+
+	codesMu.Lock()
+	entry.admitting = false
+	entry.setOrgs(orgs)
+	entry.Approval = ""
+	entry.AMR = []string{entry.Method, "telegram"}
+	entry.CreatedAt = time.Now() // the app gets the full code lifetime
+	codesMu.Unlock()
+	answerAndEdit(b, cq, botMsg(lang, "approved"))
+}
+
+// GET /approval/{code}
+func handleApproval(w http.ResponseWriter, r *http.Request) {
+	code := r.PathValue("code")
+	codesMu.Lock()
+	c, ok := codes[code]
+	status := ""
+	if ok {
+		status = c.Approval
+	}
+	codesMu.Unlock()
+	if !ok {
+		jsonErr(w, "denied or expired", http.StatusNotFound)
+		return
+	}
+	if status == "" {
+		status = "approved"
+	}
+	resp := map[string]any{"ok": true, "status": status}
+	if orgs := pendingOrgs(code); orgs != nil {
+		resp["orgs"] = orgs
+	}
+	jsonOK(w, resp)
+}
+
+// approvalPending reports whether code still waits for Telegram.
+func approvalPending(code string) bool {
+	codesMu.Lock()
+	defer codesMu.Unlock()
+	c, ok := codes[code]
+	return ok && c.Approval == "pending"
+}
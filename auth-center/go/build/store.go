@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// ── persistent state ──────────────────────────────────────────────────────
+//
+// DATA_FILE holds everything managed at runtime through the admin API.
+// It is plain JSON and may be edited by hand while the service is stopped.
+// Without DATA_FILE the state lives in memory and is lost on restart.
+
+type storeData struct {
+	Users      map[string]*UserGrants  `json:"users"`
+	Accounts   map[string][]string     `json:"accounts"`
+	Suspended  map[string]*Suspension  `json:"suspended"`
+	Orgs       map[string]*Org         `json:"orgs"`
+	OrgInvites map[string]*OrgInvite   `json:"org_invites"`
+	AppInvites map[string]*AppInvite   `json:"app_invites"`
+	Logins     map[string]*LoginRecord `json:"logins"`
+	Flagged    map[string]*ReviewFlag  `json:"flagged"`
+	OptOut     map[string][]string     `json:"opt_out"` // telegram identity → muted apps
+	Outbox     map[string]*OutMessage  `json:"outbox"`
+
+	Registered map[string]map[string]time.Time `json:"registered"` // app → identity → first login
+}
+
+const storeFlushInterval = 30 * time.Second
+
+var (
+	store    = newStoreData()
+	storeMu  sync.Mutex
+	dataFile string
+
+	// storeDirty marks changes left for the background save. Guarded by
+	// storeMu.
+	storeDirty bool
+)
+
+func newStoreData() *storeData {
+	return &storeData{
+		Users:      make(map[string]*UserGrants),
+		Accounts:   make(map[string][]string),
+		Suspended:  make(map[string]*Suspension),
+		Orgs:       make(map[string]*Org),
+		OrgInvites: make(map[string]*OrgInvite),
+		AppInvites: make(map[string]*AppInvite),
+		Logins:     make(map[string]*LoginRecord),
+		Flagged:    make(map[string]*ReviewFlag),
+		OptOut:     make(map[string][]string),
+		Outbox:     make(map[string]*OutMessage),
+		Registered: make(map[string]map[string]time.Time),
+	}
+}
+
+func loadStore(path string) error {
+	dataFile = path
+	src, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	data := newStoreData()
+	if err := json.Unmarshal(src, data); err != nil {
+		return err
+	}
+	data.fillMaps()
+	store = data
+	return nil
+}
+
+// fillMaps replaces maps left nil by a hand-edited file ("orgs": null, an
+// org without "members") with empty ones, so writes don't panic.
+func (d *storeData) fillMaps() {
+	empty := newStoreData()
+	if d.Users == nil {
+		d.Users = empty.Users
+	}
+	if d.Accounts == nil {
+		d.Accounts = empty.Accounts
+	}
+	if d.Suspended == nil {
+		d.Suspended = empty.Suspended
+	}
+	if d.Orgs == nil {
+		d.Orgs = empty.Orgs
+	}
+	if d.OrgInvites == nil {
+		d.OrgInvites = empty.OrgInvites
+	}
+	if d.AppInvites == nil {
+		d.AppInvites = empty.AppInvites
+	}
+	if d.Logins == nil {
+		d.Logins = empty.Logins
+	}
+	if d.Flagged == nil {
+		d.Flagged = empty.Flagged
+	}
+	if d.OptOut == nil {
+		d.OptOut = empty.OptOut
+	}
+	if d.Outbox == nil {
+		d.Outbox = empty.Outbox
+	}
+	if d.Registered == nil {
+		d.Registered = empty.Registered
+	}
+	for id, org := range d.Orgs {
+		if org == nil {
+			delete(d.Orgs, id)
+			continue
+		}
+		if org.Members == nil {
+			org.Members = make(map[string]string)
+		}
+	}
+}
+
+// saveStore writes the state to DATA_FILE. Caller must hold storeMu.
+func saveStore() error {
+	if dataFile == "" {
+		storeDirty = false
+		return nil
+	}
+	src, err := json.MarshalIndent(store, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(dataFile), ".auth-center-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) //nolint:errcheck
+	if _, err := tmp.Write(src); err != nil {
+		tmp.Close() //nolint:errcheck
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), dataFile); err != nil {
+		return err
+	}
+	storeDirty = false
+	return nil
+}
+
+// runStoreFlush saves frequent changes — logins, the notification outbox
+// — every storeFlushInterval instead of on each one.
+func runStoreFlush() {
+	tick := time.NewTicker(storeFlushInterval)
+	defer tick.Stop()
+	for range tick.C {
+		flushStore()
+	}
+}
+
+// flushStore prunes expired state and saves it if anything is pending.
+func flushStore() {
+	storeMu.Lock()
+	defer storeMu.Unlock()
+	pruneLogins()
+	if !storeDirty {
+		return
+	}
+	if err := saveStore(); err != nil {
+		log.Printf("save store: %v", err)
+	}
+}
+
+// identityKey names one identity across the state: "telegram:123456789",
+// "solana:5ZX8wKF...", "google:1170...".
+func identityKey(method string, id any) string {
+	return fmt.Sprintf("%s:%v", method, id)
+}
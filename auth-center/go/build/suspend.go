@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// ── suspension ────────────────────────────────────────────────────────────
+//
+// A suspended identity cannot log in with any method and its codes cannot
+// be exchanged. Suspending one identity covers every identity linked to it
+// through an account, so a wallet key, a Telegram id or a Google sub each
+// lock out the whole person.
+
+type Suspension struct {
+	Reason    string     `json:"reason,omitempty"`
+	Until     *time.Time `json:"until,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+func (s *Suspension) active() bool {
+	return s.Until == nil || time.Now().Before(*s.Until)
+}
+
+// isSuspended reports whether identity or any identity linked to it is
+// under an active suspension.
+func isSuspended(identity string) bool {
+	storeMu.Lock()
+	defer storeMu.Unlock()
+	for _, id := range linkedIdentities(identity) {
+		if s, ok := store.Suspended[id]; ok && s.active() {
+			return true
+		}
+	}
+	return false
+}
+
+// revokeIdentities drops unexchanged codes and QR sessions (scanned or
+// finished) that belong to any of ids and marks their logins revoked.
+func revokeIdentities(ids []string) {
+	storeMu.Lock()
+	var logins []string
+	for _, e := range activeLogins(ids) {
+		logins = append(logins, e.ID)
+	}
+	storeMu.Unlock()
+	revokeLogins(logins)
+
+	codesMu.Lock()
+	for k, c := range codes {
+		if slices.Contains(ids, identityKey(c.Method, c.User["id"])) {
+			delete(codes, k)
+		}
+	}
+	codesMu.Unlock()
+
+	sessionsMu.Lock()
+	for k, s := range sessions {
+		if s.Scanner != nil && slices.Contains(ids, identityKey("telegram", s.Scanner.ID)) {
+			delete(sessions, k)
+		}
+	}
+	sessionsMu.Unlock()
+}
+
+// GET /admin/suspensions
+func handleAdminListSuspensions(w http.ResponseWriter, r *http.Request) {
+	storeMu.Lock()
+	defer storeMu.Unlock()
+	jsonOK(w, store.Suspended)
+}
+
+// PUT /admin/suspensions/{identity}
+func handleAdminSuspend(w http.ResponseWriter, r *http.Request) {
+	var s Suspension
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+			jsonErr(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+	}
+	s.CreatedAt = time.Now()
+	identity := r.PathValue("identity")
+
+	storeMu.Lock()
+	store.Suspended[identity] = &s
+	err := saveStore()
+	ids := linkedIdentities(identity)
+	storeMu.Unlock()
+
+	revokeIdentities(ids)
+	if err != nil {
+		jsonErr(w, "save failed", http.StatusInternalServerError)
+		return
+	}
+	jsonOK(w, &s)
+}
+
+// DELETE /admin/suspensions/{identity}
+func handleAdminUnsuspend(w http.ResponseWriter, r *http.Request) {
+	storeMu.Lock()
+	delete(store.Suspended, r.PathValue("identity"))
+	err := saveStore()
+	storeMu.Unlock()
+	if err != nil {
+		jsonErr(w, "save failed", http.StatusInternalServerError)
+		return
+	}
+	jsonOK(w, map[string]any{"ok": true})
+}
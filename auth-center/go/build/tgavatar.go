@@ -0,0 +1,151 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ── telegram avatars ──────────────────────────────────────────────────────
+//
+// Telegram users get a "picture" URL pointing at auth-center, never at
+// the Bot API: file links there embed the bot token. The URL carries
+// an HMAC of the user id so it cannot be used to enumerate other users'
+// photos. The proxy resolves the photo with getUserProfilePhotos and
+// getFile and keeps it in memory for an hour. Users who logged in through
+// a bot from APPS_FILE get "?bot=<id>": the photo is fetched, and the URL
+// signed, with that bot's token.
+
+const (
+	avatarTTL      = time.Hour
+	avatarMaxWidth = 640
+)
+
+type avatar struct {
+	data      []byte // nil: the user has no (visible) photo
+	fetchedAt time.Time
+}
+
+var (
+	avatars   = make(map[int64]*avatar)
+	avatarsMu sync.Mutex
+
+	avatarClient = &http.Client{Timeout: 10 * time.Second, Transport: telegramTransport}
+)
+
+func avatarSig(b *Bot, id int64) string {
+	key := sha256.Sum256([]byte("avatar:" + b.Token))
+	mac := hmac.New(sha256.New, key[:])
+	mac.Write([]byte(strconv.FormatInt(id, 10)))
+	return hex.EncodeToString(mac.Sum(nil))[:32]
+}
+
+// avatarURL is the picture claim for Telegram user id as seen by b.
+func avatarURL(b *Bot, base string, id int64) string {
+	u := fmt.Sprintf("%s/telegram/avatar/%d/%s", base, id, avatarSig(b, id))
+	if b.ID != "" {
+		u += "?bot=" + url.QueryEscape(b.ID)
+	}
+	return u
+}
+
+// fetchAvatar downloads the user's current profile photo, nil if none.
+func fetchAvatar(b *Bot, id int64) ([]byte, error) {
+	var photos struct {
+		Photos [][]struct {
+			FileID string `json:"file_id"`
+			Width  int    `json:"width"`
+		} `json:"photos"`
+	}
+	err := tgRequest(b, avatarClient, "getUserProfilePhotos", map[string]any{"user_id": id, "limit": 1}, &photos)
+	if err != nil {
+		return nil, err
+	}
+	if len(photos.Photos) == 0 || len(photos.Photos[0]) == 0 {
+		return nil, nil
+	}
+	// sizes come smallest first; take the largest one within avatarMaxWidth
+	sizes := photos.Photos[0]
+	fileID := sizes[0].FileID
+	for _, s := range sizes {
+		if s.Width <= avatarMaxWidth {
+			fileID = s.FileID
+		}
+	}
+
+	var file struct {
+		FilePath string `json:"file_path"`
+	}
+	if err := tgRequest(b, avatarClient, "getFile", map[string]any{"file_id": fileID}, &file); err != nil {
+		return nil, err
+	}
+	// a local telegram-bot-api server (--local) answers with a path on its disk
+	if filepath.IsAbs(file.FilePath) {
+		f, err := os.Open(file.FilePath)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return io.ReadAll(io.LimitReader(f, 5<<20))
+	}
+	resp, err := avatarClient.Get(fmt.Sprintf("%s/file/bot%s/%s", telegramAPI, b.Token, file.FilePath))
+	if err != nil {
+		return nil, tgNetErr("getFile download", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("getFile download: %s", resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 5<<20))
+}
+
+// GET /telegram/avatar/{id}/{sig}
+func handleTelegramAvatar(w http.ResponseWriter, r *http.Request) {
+	b := defaultBot
+	if bot := r.URL.Query().Get("bot"); bot != "" {
+		b = bots[bot]
+	}
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || b == nil ||
+		!hmac.Equal([]byte(r.PathValue("sig")), []byte(avatarSig(b, id))) {
+		http.NotFound(w, r)
+		return
+	}
+
+	avatarsMu.Lock()
+	for k, a := range avatars {
+		if time.Since(a.fetchedAt) > avatarTTL {
+			delete(avatars, k)
+		}
+	}
+	a, ok := avatars[id]
+	avatarsMu.Unlock()
+
+	if !ok {
+		data, err := fetchAvatar(b, id)
+		if err != nil {
+			http.Error(w, "telegram unavailable", http.StatusBadGateway)
+			return
+		}
+		a = &avatar{data: data, fetchedAt: time.Now()}
+		avatarsMu.Lock()
+		avatars[id] = a
+		avatarsMu.Unlock()
+	}
+	if a.data == nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	w.Write(a.data) //nolint:errcheck
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ── telegram chat membership ──────────────────────────────────────────────
+//
+// An app listing "chats" (ids like "-1001234567890" or "@channel") admits
+// only members of every one of them. The app's bot must be in each chat
+// (an admin, for channels); membership is checked with getChatMember when
+// the code is issued. Google and Solana logins are checked through a
+// linked Telegram identity. The membership status per chat becomes the "chats"
+// claim: "owner", "admin" or "member".
+
+var (
+	errNotChatMember = errors.New("not a member of the required telegram chat")
+	errNoTelegram    = errors.New("a linked telegram account is required")
+
+	chatsClient = &http.Client{Timeout: 5 * time.Second, Transport: telegramTransport}
+)
+
+// chatRoles maps getChatMember statuses that are let in.
+var chatRoles = map[string]string{
+	"creator":       "owner",
+	"administrator": "admin",
+	"member":        "member",
+}
+
+// telegramID finds the Telegram user behind identity, directly or linked.
+func telegramID(identity string) (int64, bool) {
+	storeMu.Lock()
+	ids := linkedIdentities(identity)
+	storeMu.Unlock()
+	for _, id := range ids {
+		if raw, ok := strings.CutPrefix(id, "telegram:"); ok {
+			if n, err := strconv.ParseInt(raw, 10, 64); err == nil {
+				return n, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// checkChats verifies identity is a member of all app chats and returns
+// chat → role.
+func checkChats(app *App, identity string) (map[string]any, error) {
+	if app == nil || len(app.Chats) == 0 {
+		return nil, nil
+	}
+	userID, ok := telegramID(identity)
+	if !ok {
+		return nil, errNoTelegram
+	}
+	roles := make(map[string]any)
+	for _, chat := range app.Chats {
+		var member struct {
+			Status string `json:"status"`
+		}
+		err := tgRequest(appBot(app), chatsClient, "getChatMember", map[string]any{"chat_id": chat, "user_id": userID}, &member)
+		if err != nil {
+			log.Printf("app %q chat %s: %v", app.ID, chat, err)
+			return nil, errNotChatMember
+		}
+		role, ok := chatRoles[member.Status]
+		if !ok {
+			return nil, errNotChatMember
+		}
+		roles[chat] = role
+	}
+	return roles, nil
+}
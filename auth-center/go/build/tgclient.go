@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ── telegram client ───────────────────────────────────────────────────────
+//
+// tgRequest is the one place that talks to the Bot API. Fire-and-forget
+// messages (tgCall, sendTG) go through a bounded queue served by a few
+// workers; each chat always lands on the same worker so its messages keep
+// their order. A worker retries network errors and 5xx with exponential
+// backoff, waits out 429 retry_after, and gives up at once on other 4xx —
+// 403 ("bot was blocked by the user", "user is deactivated") included.
+// Every call is counted for GET /metrics.
+
+const (
+	tgWorkers     = 4
+	tgQueueSize   = 256 // per worker
+	tgMaxAttempts = 5
+	tgMaxBackoff  = 30 * time.Second
+)
+
+// tgAPIError is a response with "ok": false.
+type tgAPIError struct {
+	Method      string
+	Code        int
+	Description string
+	RetryAfter  int // seconds, on 429
+}
+
+func (e *tgAPIError) Error() string {
+	return fmt.Sprintf("%s: %d %s", e.Method, e.Code, e.Description)
+}
+
+// permanent reports whether retrying cannot help.
+func (e *tgAPIError) permanent() bool {
+	return e.Code >= 400 && e.Code < 500 && e.Code != http.StatusTooManyRequests
+}
+
+type tgJob struct {
+	bot     *Bot
+	method  string
+	payload any
+}
+
+var (
+	errNoBot = errors.New("telegram bot is not configured")
+
+	tgClient = &http.Client{Timeout: 10 * time.Second, Transport: telegramTransport}
+	tgQueues []chan tgJob
+	tgQueued sync.WaitGroup // jobs not finished yet, for drainTG
+
+	tgStats = struct {
+		sync.Mutex
+		calls   map[[2]string]int // method, result → count
+		retries int
+		dropped int
+	}{calls: make(map[[2]string]int)}
+)
+
+// tgRequest calls a Bot API method of b and decodes its result into out.
+func tgRequest(b *Bot, client *http.Client, method string, payload, out any) error {
+	if b == nil {
+		return errNoBot
+	}
+	err := tgDo(b, client, method, payload, out)
+	result := "ok"
+	var apiErr *tgAPIError
+	switch {
+	case err == nil:
+	case errors.As(err, &apiErr) && apiErr.Code == http.StatusForbidden:
+		result = "blocked"
+	case errors.As(err, &apiErr) && apiErr.Code == http.StatusTooManyRequests:
+		result = "rate_limited"
+	default:
+		result = "error"
+	}
+	tgStats.Lock()
+	tgStats.calls[[2]string{method, result}]++
+	tgStats.Unlock()
+	return err
+}
+
+func tgDo(b *Bot, client *http.Client, method string, payload, out any) error {
+	endpoint := fmt.Sprintf("%s/bot%s/%s", telegramAPI, b.Token, method)
+	body, _ := json.Marshal(payload)
+	resp, err := client.Post(endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return tgNetErr(method, err)
+	}
+	defer resp.Body.Close()
+	var res struct {
+		OK          bool            `json:"ok"`
+		Result      json.RawMessage `json:"result"`
+		ErrorCode   int             `json:"error_code"`
+		Description string          `json:"description"`
+		Parameters  struct {
+			RetryAfter int `json:"retry_after"`
+		} `json:"parameters"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return fmt.Errorf("%s: %s", method, resp.Status)
+	}
+	if !res.OK {
+		return &tgAPIError{
+			Method:      method,
+			Code:        res.ErrorCode,
+			Description: res.Description,
+			RetryAfter:  res.Parameters.RetryAfter,
+		}
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(res.Result, out)
+}
+
+// tgNetErr names a failed request by method alone: the *url.Error from
+// http.Client quotes the URL, and with it the bot token.
+func tgNetErr(method string, err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		err = urlErr.Err
+	}
+	return fmt.Errorf("%s: %w", method, err)
+}
+
+// tgRetryDelay is how long to wait before attempt n+1 after err, or false
+// when err is final.
+func tgRetryDelay(err error, n int) (time.Duration, bool) {
+	var apiErr *tgAPIError
+	if errors.As(err, &apiErr) {
+		if apiErr.Code == http.StatusTooManyRequests && apiErr.RetryAfter > 0 {
+			return time.Duration(apiErr.RetryAfter) * time.Second, true
+		}
+		if apiErr.permanent() {
+			return 0, false
+		}
+	}
+	return min(time.Second<<n, tgMaxBackoff), true
+}
+
+func startTGWorkers() {
+	for range tgWorkers {
+		q := make(chan tgJob, tgQueueSize)
+		tgQueues = append(tgQueues, q)
+		go tgWorker(q)
+	}
+}
+
+func tgWorker(q chan tgJob) {
+	for job := range q {
+		runTGJob(job)
+		tgQueued.Done()
+	}
+}
+
+func runTGJob(job tgJob) {
+	for n := 0; ; n++ {
+		err := tgRequest(job.bot, tgClient, job.method, job.payload, nil)
+		if err == nil {
+			return
+		}
+		delay, retry := tgRetryDelay(err, n)
+		if !retry || n+1 >= tgMaxAttempts {
+			log.Printf("telegram %s: %v (giving up)", botName(job.bot), err)
+			return
+		}
+		tgStats.Lock()
+		tgStats.retries++
+		tgStats.Unlock()
+		time.Sleep(delay)
+	}
+}
+
+// drainTG waits up to timeout for the queued calls to finish.
+func drainTG(timeout time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		tgQueued.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		log.Printf("telegram: shutting down with calls still queued")
+	}
+}
+
+// tgCall queues payload for a Bot API method of b; delivery failures are
+// logged and counted, never returned.
+func tgCall(b *Bot, method string, payload any) {
+	var chat int64
+	if m, ok := payload.(map[string]any); ok {
+		chat, _ = m["chat_id"].(int64)
+	}
+	tgQueue(b, chat, method, payload)
+}
+
+// tgQueue puts the call on the worker of chat.
+func tgQueue(b *Bot, chat int64, method string, payload any) {
+	if b == nil || len(tgQueues) == 0 {
+		return
+	}
+	i := int(uint64(chat) % tgWorkers)
+	tgQueued.Add(1)
+	select {
+	case tgQueues[i] <- tgJob{b, method, payload}:
+	default:
+		tgQueued.Done()
+		log.Printf("telegram: queue full, dropping %s", method)
+		tgStats.Lock()
+		tgStats.dropped++
+		tgStats.Unlock()
+	}
+}
+
+func sendTG(b *Bot, chatID int64, text string) {
+	tgCall(b, "sendMessage", map[string]any{"chat_id": chatID, "text": text})
+}
+
+// answerCallback acknowledges a button tap, with an optional toast text.
+// It has no chat_id, so it is queued by the user who tapped: answers must
+// not all wait on one worker.
+func answerCallback(b *Bot, cq *tgCallbackQuery, text string) {
+	payload := map[string]any{"callback_query_id": cq.ID}
+	if text != "" {
+		payload["text"] = text
+	}
+	tgQueue(b, cq.From.ID, "answerCallbackQuery", payload)
+}
+
+// answerAndEdit acknowledges a button tap and replaces the message that
+// carried the buttons with text.
+func answerAndEdit(b *Bot, cq *tgCallbackQuery, text string) {
+	answerCallback(b, cq, "")
+	if cq.Message != nil {
+		tgCall(b, "editMessageText", map[string]any{
+			"chat_id":    cq.Message.Chat.ID,
+			"message_id": cq.Message.MessageID,
+			"text":       text,
+		})
+	}
+}
+
+// GET /metrics
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	var b strings.Builder
+	tgStats.Lock()
+	keys := make([][2]string, 0, len(tgStats.calls))
+	for k := range tgStats.calls {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i][0]+keys[i][1] < keys[j][0]+keys[j][1]
+	})
+	b.WriteString("# TYPE auth_center_telegram_requests_total counter\n")
+	for _, k := range keys {
+		fmt.Fprintf(&b, "auth_center_telegram_requests_total{method=%q,result=%q} %d\n", k[0], k[1], tgStats.calls[k])
+	}
+	fmt.Fprintf(&b, "# TYPE auth_center_telegram_retries_total counter\nauth_center_telegram_retries_total %d\n", tgStats.retries)
+	fmt.Fprintf(&b, "# TYPE auth_center_telegram_dropped_total counter\nauth_center_telegram_dropped_total %d\n", tgStats.dropped)
+	tgStats.Unlock()
+
+	queued := 0
+	for _, q := range tgQueues {
+		queued += len(q)
+	}
+	fmt.Fprintf(&b, "# TYPE auth_center_telegram_queue_length gauge\nauth_center_telegram_queue_length %d\n", queued)
+	storeMu.Lock()
+	outbox := len(store.Outbox)
+	storeMu.Unlock()
+	fmt.Fprintf(&b, "# TYPE auth_center_outbox_length gauge\nauth_center_outbox_length %d\n", outbox)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write([]byte(b.String())) //nolint:errcheck
+}
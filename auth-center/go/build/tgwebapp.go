@@ -0,0 +1,177 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ── telegram mini apps ────────────────────────────────────────────────────
+//
+// A Mini App posts Telegram.WebApp.initData to /telegram/webapp. The hash
+// is an HMAC-SHA256 of the sorted "key=value" lines, keyed with
+// HMAC-SHA256("WebAppData", BOT_TOKEN). With WEBAPP_PUBLIC_KEY set (hex,
+// Telegram's production or test key from "Validating data for Third-Party
+// Use"), the Ed25519 "signature" field must verify against it as well.
+// The Mini App may belong to any configured bot.
+//
+// Mini Apps hand initData to their own backends too, so it is accepted
+// once and only while fresh: otherwise any backend of the same bot could
+// replay it to log the user in elsewhere.
+
+const webAppMaxAge = 5 * time.Minute
+
+var (
+	webAppPublicKey ed25519.PublicKey
+	webAppHashes    = newOneTime(webAppMaxAge)
+)
+
+// dataCheckString joins the sorted "key=value" pairs of vals without skip.
+func dataCheckString(vals url.Values, skip ...string) string {
+	var lines []string
+	for k := range vals {
+		if !slices.Contains(skip, k) {
+			lines = append(lines, k+"="+vals.Get(k))
+		}
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
+}
+
+// checkWebAppData verifies initData and returns its parsed fields and the
+// bot it was signed for.
+func checkWebAppData(initData string) (url.Values, *Bot) {
+	vals, err := url.ParseQuery(initData)
+	if err != nil {
+		return nil, nil
+	}
+
+	var bot *Bot
+	for _, b := range allBots() {
+		secret := hmac.New(sha256.New, []byte("WebAppData"))
+		secret.Write([]byte(b.Token))
+		mac := hmac.New(sha256.New, secret.Sum(nil))
+		mac.Write([]byte(dataCheckString(vals, "hash")))
+		want := hex.EncodeToString(mac.Sum(nil))
+		if hmac.Equal([]byte(want), []byte(vals.Get("hash"))) {
+			bot = b
+			break
+		}
+	}
+	if bot == nil {
+		return nil, nil
+	}
+
+	if webAppPublicKey != nil {
+		sig, err := base64.RawURLEncoding.DecodeString(vals.Get("signature"))
+		if err != nil {
+			return nil, nil
+		}
+		botID, _, _ := strings.Cut(bot.Token, ":")
+		msg := botID + ":WebAppData\n" + dataCheckString(vals, "hash", "signature")
+		if !ed25519.Verify(webAppPublicKey, []byte(msg), sig) {
+			return nil, nil
+		}
+	}
+	return vals, bot
+}
+
+// POST /telegram/webapp
+func handleTelegramWebApp(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Access-Control-Allow-Methods", "POST")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	var body struct {
+		InitData  string `json:"init_data"`
+		Redirect  string `json:"redirect"`
+		AppToken  string `json:"app_token"`
+		Invite    string `json:"invite"`
+		OrgInvite string `json:"org_invite"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.InitData == "" {
+		jsonErr(w, "no data", http.StatusBadRequest)
+		return
+	}
+
+	vals, b := checkWebAppData(body.InitData)
+	if b == nil {
+		jsonErr(w, "invalid init data", http.StatusForbidden)
+		return
+	}
+	authDate, err := strconv.ParseInt(vals.Get("auth_date"), 10, 64)
+	if err != nil || time.Since(time.Unix(authDate, 0)) > webAppMaxAge {
+		jsonErr(w, "init data expired", http.StatusForbidden)
+		return
+	}
+
+	// a Mini App backend names its app by token instead of a redirect
+	var app *App
+	if body.AppToken != "" {
+		if app = appByToken(body.AppToken); app == nil {
+			jsonErr(w, "unauthorized", http.StatusForbidden)
+			return
+		}
+		if appBot(app) != b {
+			jsonErr(w, "init data is for another bot", http.StatusForbidden)
+			return
+		}
+	}
+	if !webAppHashes.use(vals.Get("hash")) {
+		jsonErr(w, "init data already used", http.StatusForbidden)
+		return
+	}
+
+	var from tgUser
+	if err := json.Unmarshal([]byte(vals.Get("user")), &from); err != nil || from.ID == 0 {
+		jsonErr(w, "no user in init data", http.StatusBadRequest)
+		return
+	}
+	if isSuspended(identityKey("telegram", from.ID)) {
+		jsonErr(w, "account suspended", http.StatusForbidden)
+		return
+	}
+
+	user := from.userMap(b, publicURL(r))
+	resp := map[string]any{"ok": true, "user": user}
+	if body.Redirect != "" || app != nil {
+		code, err := issueCode(&login{
+			Method:    "telegram",
+			User:      user,
+			Redirect:  body.Redirect,
+			IP:        clientIP(r),
+			UserAgent: r.UserAgent(),
+			Invite:    body.Invite,
+			OrgInvite: body.OrgInvite,
+			App:       app,
+		})
+		if err != nil {
+			jsonErr(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		resp["code"] = code
+		if body.Redirect != "" {
+			resp["redirect"] = body.Redirect
+		}
+		if orgs := pendingOrgs(code); orgs != nil {
+			resp["orgs"] = orgs
+		}
+	} else {
+		redeemOrgInvite(body.OrgInvite, identityKey("telegram", from.ID))
+	}
+	jsonOK(w, resp)
+}
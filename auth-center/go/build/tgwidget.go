@@ -0,0 +1,119 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ── telegram login widget ─────────────────────────────────────────────────
+//
+// One-click login with the official Telegram Login Widget. The widget's
+// data-auth-url points at /telegram/widget?redirect=...; Telegram appends
+// the user fields, auth_date and hash. The hash is an HMAC-SHA256 of the
+// sorted "key=value" lines keyed with SHA256(BOT_TOKEN); the widget shown
+// is that of the app's bot, but a hash from any configured bot is taken.
+//
+// The bot's domain must be set once in @BotFather with /setdomain.
+
+const widgetMaxAge = 5 * time.Minute
+
+// seen widget hashes, so a signed callback URL works only once
+var widgetHashes = newOneTime(widgetMaxAge)
+
+// oneTime remembers keys for ttl, so signed login data is taken once.
+type oneTime struct {
+	mu   sync.Mutex
+	ttl  time.Duration
+	seen map[string]time.Time
+}
+
+func newOneTime(ttl time.Duration) *oneTime {
+	return &oneTime{ttl: ttl, seen: make(map[string]time.Time)}
+}
+
+// use records key and reports whether it was fresh.
+func (o *oneTime) use(key string) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for k, t := range o.seen {
+		if time.Since(t) > o.ttl {
+			delete(o.seen, k)
+		}
+	}
+	if _, ok := o.seen[key]; ok {
+		return false
+	}
+	o.seen[key] = time.Now()
+	return true
+}
+
+// checkWidgetHash verifies the Login Widget fields in q and returns the
+// bot that signed them, nil if none did.
+func checkWidgetHash(q url.Values) *Bot {
+	hash := q.Get("hash")
+	if hash == "" {
+		return nil
+	}
+	data := dataCheckString(q, "hash", "redirect", "invite", "org_invite")
+	for _, b := range allBots() {
+		secret := sha256.Sum256([]byte(b.Token))
+		mac := hmac.New(sha256.New, secret[:])
+		mac.Write([]byte(data))
+		want := hex.EncodeToString(mac.Sum(nil))
+		if hmac.Equal([]byte(want), []byte(hash)) {
+			return b
+		}
+	}
+	return nil
+}
+
+// GET /telegram/widget
+func handleTelegramWidget(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	b := checkWidgetHash(q)
+	if b == nil {
+		http.Error(w, "invalid hash", http.StatusForbidden)
+		return
+	}
+	authDate, err := strconv.ParseInt(q.Get("auth_date"), 10, 64)
+	if err != nil || time.Since(time.Unix(authDate, 0)) > widgetMaxAge {
+		http.Error(w, "auth data expired", http.StatusForbidden)
+		return
+	}
+	if !widgetHashes.use(q.Get("hash")) {
+		http.Error(w, "auth data already used", http.StatusForbidden)
+		return
+	}
+	id, err := strconv.ParseInt(q.Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+
+	if isSuspended(identityKey("telegram", id)) {
+		http.Error(w, "account suspended", http.StatusForbidden)
+		return
+	}
+
+	user := tgUser{
+		ID:        id,
+		FirstName: q.Get("first_name"),
+		LastName:  q.Get("last_name"),
+		Username:  q.Get("username"),
+	}.userMap(b, publicURL(r))
+	loginRedirect(w, r, &login{
+		Method:    "telegram",
+		User:      user,
+		Redirect:  q.Get("redirect"),
+		IP:        clientIP(r),
+		UserAgent: r.UserAgent(),
+		Invite:    q.Get("invite"),
+		OrgInvite: q.Get("org_invite"),
+	})
+}
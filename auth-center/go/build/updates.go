@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ── telegram update router ────────────────────────────────────────────────
+//
+// Every update, from the webhook or getUpdates, goes through handleUpdate.
+// Telegram redelivers a webhook update it got no answer for, so update_ids
+// already seen by the bot are dropped. Messages and edited messages are
+// served alike, in private chats only; anything the bot does not
+// understand — unknown commands, stickers, photos — gets a short help
+// text in the sender's language. my_chat_member tells when a user blocks
+// the bot: their queued notifications are dropped instead of retried.
+
+const updateDedupTTL = time.Hour
+
+type seenUpdate struct {
+	bot string
+	id  int64
+}
+
+var (
+	seenUpdates   = make(map[seenUpdate]time.Time)
+	seenUpdatesMu sync.Mutex
+)
+
+// firstDelivery records update id for b and reports whether it is new.
+func firstDelivery(b *Bot, id int64) bool {
+	seenUpdatesMu.Lock()
+	defer seenUpdatesMu.Unlock()
+	for k, t := range seenUpdates {
+		if time.Since(t) > updateDedupTTL {
+			delete(seenUpdates, k)
+		}
+	}
+	key := seenUpdate{botName(b), id}
+	if _, ok := seenUpdates[key]; ok {
+		return false
+	}
+	seenUpdates[key] = time.Now()
+	return true
+}
+
+// handleUpdate serves an update received by bot b.
+func handleUpdate(b *Bot, u *tgUpdate) {
+	if !firstDelivery(b, u.UpdateID) {
+		return
+	}
+	switch {
+	case u.Message != nil:
+		handleMessage(b, u.Message)
+	case u.EditedMessage != nil:
+		handleMessage(b, u.EditedMessage)
+	case u.CallbackQuery != nil:
+		handleCallback(b, u.CallbackQuery)
+	case u.MyChatMember != nil:
+		handleMyChatMember(b, u.MyChatMember)
+	}
+}
+
+func handleMessage(b *Bot, msg *tgMessage) {
+	// in groups the bot only listens; it answers people one to one
+	if msg.Chat.Type != "" && msg.Chat.Type != "private" {
+		return
+	}
+	if msg.Contact != nil {
+		handleContact(b, msg)
+		return
+	}
+	from := msg.From
+	lang := userLang(from, nil)
+	switch cmd, args := botCommand(msg.Text); cmd {
+	case "/start":
+		if args == "" {
+			sendTG(b, from.ID, botHelp(lang))
+			return
+		}
+		handleStart(b, msg, args)
+	case "/help":
+		sendTG(b, from.ID, botHelp(lang))
+	case "/sessions":
+		handleSessions(b, from)
+	case "/notifications":
+		handleNotifications(b, from)
+	case "/logout_all":
+		n := logoutAll(from)
+		sendTG(b, from.ID, botMsg(lang, "logged_out", n))
+	default:
+		text := botHelp(lang)
+		if strings.HasPrefix(cmd, "/") {
+			text = botMsg(lang, "unknown_command", cmd) + text
+		}
+		sendTG(b, from.ID, text)
+	}
+}
+
+// botCommand splits "/cmd@bot args" into "/cmd" and "args".
+func botCommand(text string) (string, string) {
+	cmd, args, _ := strings.Cut(text, " ")
+	cmd, _, _ = strings.Cut(cmd, "@")
+	return cmd, strings.TrimSpace(args)
+}
+
+// botHelp lists what the bot can do, in lang.
+func botHelp(lang string) string {
+	var text strings.Builder
+	text.WriteString(botMsg(lang, "help"))
+	for _, c := range commandList(lang) {
+		if c["command"] != "start" {
+			fmt.Fprintf(&text, "\n/%s — %s", c["command"], c["description"])
+		}
+	}
+	return text.String()
+}
+
+// handleMyChatMember follows the bot's membership: a user blocking or
+// unblocking it, or the bot being added to or removed from a chat.
+func handleMyChatMember(b *Bot, m *tgChatMemberUpdated) {
+	status := m.NewChatMember.Status
+	if m.Chat.Type != "private" {
+		log.Printf("telegram %s: now %s in chat %d", botName(b), status, m.Chat.ID)
+		return
+	}
+	if status != "kicked" {
+		return
+	}
+
+	storeMu.Lock()
+	dropped := 0
+	for id, msg := range store.Outbox {
+		if msg.ChatID == m.Chat.ID && appBot(apps[msg.App]) == b {
+			delete(store.Outbox, id)
+			dropped++
+		}
+	}
+	if dropped > 0 {
+		storeDirty = true
+	}
+	storeMu.Unlock()
+	log.Printf("telegram %s: blocked by %d, %d queued notification(s) dropped", botName(b), m.Chat.ID, dropped)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ── upstreams ─────────────────────────────────────────────────────────────
+//
+// Outbound calls go through one transport per upstream, so Telegram and
+// Google can each use their own proxy: TELEGRAM_PROXY and GOOGLE_PROXY
+// take http://, https:// (HTTP CONNECT) or socks5:// URLs, with optional
+// user:password. Unset, the usual HTTPS_PROXY/NO_PROXY variables apply.
+
+var (
+	telegramProxy *url.URL
+	googleProxy   *url.URL
+
+	telegramTransport = upstreamTransport(&telegramProxy)
+	googleTransport   = upstreamTransport(&googleProxy)
+)
+
+func upstreamTransport(proxy **url.URL) *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.Proxy = func(r *http.Request) (*url.URL, error) {
+		if *proxy != nil {
+			return *proxy, nil
+		}
+		return http.ProxyFromEnvironment(r)
+	}
+	return t
+}
+
+// parseProxy validates a *_PROXY value; "" means no dedicated proxy.
+func parseProxy(raw string) (*url.URL, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("proxy %q has no host", raw)
+	}
+	return u, nil
+}
+
+// ── upstreams: base URLs ──────────────────────────────────────────────────
+//
+// TELEGRAM_API_URL points the bot at a self-hosted telegram-bot-api server;
+// the GOOGLE_*_URL variables let test environments stand in for Google.
+
+var (
+	telegramAPI    = "https://api.telegram.org"
+	googleAuthAPI  = "https://accounts.google.com"
+	googleTokenAPI = "https://oauth2.googleapis.com"
+	googleUserAPI  = "https://www.googleapis.com"
+)
+
+// baseURL validates a *_URL value and strips its trailing slash; ""
+// keeps def.
+func baseURL(raw, def string) (string, error) {
+	if raw == "" {
+		return def, nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("%q is not an http(s) URL", raw)
+	}
+	return strings.TrimRight(raw, "/"), nil
+}
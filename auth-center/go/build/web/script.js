@@ -17,17 +17,43 @@ function selectMethod(method) {
   document.getElementById(`tile-${method}`).classList.add('active');
   document.getElementById(`section-${method}`).classList.add('open');
 
-  if (method === 'tg') startSession();
-
-  if (method === 'google') {
-    const r = window.REDIRECT_URL || '';
-    const href = '/google/login' + (r ? '?redirect=' + encodeURIComponent(r) : '');
-    document.getElementById('google-btn').href = href;
+  if (method === 'tg') {
+    startSession();
+    mountTelegramWidget();
   }
+
+  if (method === 'google') updateGoogleLink();
+}
+
+// ── invite code ───────────────────────────────────────────────────────────
+
+function inviteCode() {
+  const el = document.getElementById('invite-code');
+  return el ? el.value.trim() : '';
+}
+
+// the QR session and google link carry the code, so rebuild them
+function inviteChanged() {
+  if (activeMethod === 'tg')     { startSession(); mountTelegramWidget(); }
+  if (activeMethod === 'google') updateGoogleLink();
+}
+
+function updateGoogleLink() {
+  const params = new URLSearchParams();
+  if (window.REDIRECT_URL) params.set('redirect', window.REDIRECT_URL);
+  if (window.ORG_INVITE)   params.set('org_invite', window.ORG_INVITE);
+  if (inviteCode())        params.set('invite', inviteCode());
+  const q = params.toString();
+  document.getElementById('google-btn').href = '/google/login' + (q ? '?' + q : '');
 }
 
 // ── shared ────────────────────────────────────────────────────────────────
 
+// t looks up a page string in the catalog picked by the server
+function t(key) {
+  return (window.T && window.T[key]) || key;
+}
+
 function showResult(type, text) {
   const el = document.getElementById('result');
   el.className = 'result ' + type;
@@ -38,19 +64,87 @@ function lockAll() {
   document.querySelectorAll('.tile').forEach(t => t.disabled = true);
 }
 
-function navigateWithCode(redirectUrl, code) {
+function navigateWithCode(redirectUrl, code, orgs) {
+  if (orgs && orgs.length > 1) {
+    showOrgPicker(redirectUrl, code, orgs);
+    return;
+  }
   const sep = redirectUrl.includes('?') ? '&' : '?';
   window.location.href = `${redirectUrl}${sep}code=${code}`;
 }
 
+// ── organization picker ───────────────────────────────────────────────────
+
+function showOrgPicker(redirectUrl, code, orgs) {
+  lockAll();
+  document.querySelectorAll('.section').forEach(s => s.classList.remove('open'));
+
+  const list = document.getElementById('org-list');
+  list.innerHTML = '';
+  for (const org of orgs) {
+    const btn = document.createElement('button');
+    btn.className   = 'action-btn';
+    btn.textContent = `${org.name} (${org.role})`;
+    btn.onclick     = () => selectOrg(redirectUrl, code, org.id);
+    list.appendChild(btn);
+  }
+  document.getElementById('section-org').classList.add('open');
+}
+
+async function selectOrg(redirectUrl, code, org) {
+  const data = await fetch('/org/select', {
+    method: 'POST',
+    headers: { 'Content-Type': 'application/json' },
+    body: JSON.stringify({ code, org }),
+  }).then(r => r.json());
+
+  if (data.ok) {
+    navigateWithCode(redirectUrl, code);
+    return;
+  }
+  document.getElementById('section-org').classList.remove('open');
+  showResult('error', data.error || t('org_failed'));
+}
+
+if (window.ORG_PICK) {
+  showOrgPicker(window.REDIRECT_URL || '', window.ORG_PICK.code, window.ORG_PICK.orgs);
+}
+
+// ── telegram second factor ────────────────────────────────────────────────
+
+function waitForApproval(redirectUrl, code) {
+  lockAll();
+  document.querySelectorAll('.section').forEach(s => s.classList.remove('open'));
+  document.getElementById('section-approve').classList.add('open');
+
+  const timer = setInterval(async () => {
+    const data = await fetch(`/approval/${code}`).then(r => r.json());
+    if (data.status === 'pending') return;
+    clearInterval(timer);
+    document.getElementById('section-approve').classList.remove('open');
+    if (data.status === 'approved') {
+      navigateWithCode(redirectUrl, code, data.orgs);
+      return;
+    }
+    showResult('error', data.error || t('login_denied'));
+  }, 2000);
+}
+
+if (window.APPROVE_CODE) {
+  waitForApproval(window.REDIRECT_URL || '', window.APPROVE_CODE);
+}
+
 // ── telegram QR ───────────────────────────────────────────────────────────
 
 let pollInterval = null;
 let pollTimeout  = null;
+let scanned      = false;
 
 async function startSession() {
   clearInterval(pollInterval);
   clearTimeout(pollTimeout);
+  scanned = false;
+  document.getElementById('tg-confirm').classList.remove('visible');
 
   document.getElementById('qr-area').classList.remove('hidden');
   document.getElementById('tg-refresh').style.display = 'none';
@@ -59,7 +153,11 @@ async function startSession() {
   const { token, qr, url } = await fetch('/qr-session', {
     method: 'POST',
     headers: { 'Content-Type': 'application/json' },
-    body: JSON.stringify({ redirect: window.REDIRECT_URL || '' }),
+    body: JSON.stringify({
+      redirect:   window.REDIRECT_URL || '',
+      invite:     inviteCode(),
+      org_invite: window.ORG_INVITE || '',
+    }),
   }).then(r => r.json());
 
   document.getElementById('qr-img').src = `data:image/png;base64,${qr}`;
@@ -74,15 +172,60 @@ async function startSession() {
   }, 20000);
 }
 
+// ── telegram login widget ─────────────────────────────────────────────────
+//
+// One-click login for desktop users with Telegram installed. The widget
+// redirects to /telegram/widget with the signed user data appended.
+
+function mountTelegramWidget() {
+  const box = document.getElementById('tg-widget');
+  box.innerHTML = '';
+  if (!window.BOT_USERNAME) return;
+
+  const params = new URLSearchParams();
+  if (window.REDIRECT_URL) params.set('redirect', window.REDIRECT_URL);
+  if (window.ORG_INVITE)   params.set('org_invite', window.ORG_INVITE);
+  if (inviteCode())        params.set('invite', inviteCode());
+  const q = params.toString();
+
+  const s = document.createElement('script');
+  s.async = true;
+  s.src   = 'https://telegram.org/js/telegram-widget.js?22';
+  s.setAttribute('data-telegram-login', window.BOT_USERNAME);
+  s.setAttribute('data-size', 'large');
+  s.setAttribute('data-radius', '10');
+  if (window.LANG) s.setAttribute('data-lang', window.LANG);
+  s.setAttribute('data-auth-url', window.location.origin + '/telegram/widget' + (q ? '?' + q : ''));
+  box.appendChild(s);
+}
+
 async function poll(token) {
   const data = await fetch(`/poll/${token}`).then(r => r.json());
 
+  if (data.status === 'scanned' && !scanned) {
+    // give the user time to read and approve the prompt in telegram
+    scanned = true;
+    clearTimeout(pollTimeout);
+    pollTimeout = setTimeout(() => {
+      clearInterval(pollInterval);
+      document.getElementById('qr-area').classList.add('hidden');
+      document.getElementById('tg-refresh').style.display = 'block';
+    }, 120000);
+    document.getElementById('qr-area').classList.add('hidden');
+    document.getElementById('tg-number').textContent = data.number;
+    document.getElementById('tg-confirm').classList.add('visible');
+  }
+
+  if (data.status && data.status !== 'scanned') {
+    document.getElementById('tg-confirm').classList.remove('visible');
+  }
+
   if (data.status === 'authenticated') {
     clearInterval(pollInterval);
     clearTimeout(pollTimeout);
 
     if (data.redirect && data.code) {
-      navigateWithCode(data.redirect, data.code);
+      navigateWithCode(data.redirect, data.code, data.orgs);
       return;
     }
 
@@ -96,6 +239,13 @@ async function poll(token) {
     );
   }
 
+  if (data.status === 'denied') {
+    clearInterval(pollInterval);
+    clearTimeout(pollTimeout);
+    document.getElementById('qr-area').classList.add('hidden');
+    showResult('error', data.error || t('access_denied'));
+  }
+
   if (data.status === 'expired') {
     clearInterval(pollInterval);
     document.getElementById('qr-area').classList.add('hidden');
@@ -168,7 +318,7 @@ async function signWithInjected(injected, btn) {
   await injected.wallet.connect();
   const publicKey = injected.wallet.publicKey.toBase58();
 
-  btn.textContent = 'signing...';
+  btn.textContent = t('signing');
   const { nonce, token, error } = await fetchNonce();
   if (error) throw new Error(error);
 
@@ -219,7 +369,7 @@ async function signWithStandard(wallet, btn) {
   if (!account) throw new Error('wallet connected but no account');
   const publicKey = toBase58(account.publicKey);
 
-  btn.textContent = 'signing...';
+  btn.textContent = t('signing');
   const { nonce, token, error } = await fetchNonce();
   if (error) throw new Error(error);
 
@@ -258,7 +408,7 @@ async function signWithMWA(btn) {
   const nonceBase64 = btoa(String.fromCharCode(...nonceBytes));
 
   return await _mwaTransact(async (wallet) => {
-    btn.textContent = 'opening wallet...';
+    btn.textContent = t('opening_wallet');
 
     const authResult = await wallet.authorize({
       chain: 'solana:mainnet',
@@ -283,7 +433,7 @@ async function signWithMWA(btn) {
       publicKey = toBase58(pkBytes);
     }
 
-    btn.textContent = 'signing...';
+    btn.textContent = t('signing');
 
     const { signed_payloads } = await wallet.signMessages({
       addresses: [rawAddress],
@@ -325,7 +475,7 @@ async function connectWallet() {
   phantomEl.style.display = 'none';
   btn.classList.remove('invalid');
   btn.disabled    = true;
-  btn.textContent = 'connecting...';
+  btn.textContent = t('connecting');
 
   try {
     let result = null;
@@ -338,7 +488,7 @@ async function connectWallet() {
 
     // 2. wallet standard
     if (!result) {
-      btn.textContent = 'looking for wallet...';
+      btn.textContent = t('looking_wallet');
       const stdWallet = await findStandardWallet();
       if (stdWallet) result = await signWithStandard(stdWallet, btn);
     }
@@ -352,7 +502,7 @@ async function connectWallet() {
     if (!result) {
       btn.classList.add('invalid');
       btn.disabled    = false;
-      btn.textContent = 'connect wallet';
+      btn.textContent = t('connect_wallet');
       IS_MOBILE ? showPhantomLink() : noWallet.classList.add('visible');
       return;
     }
@@ -367,24 +517,30 @@ async function connectWallet() {
         nonce:       result.nonce,
         nonce_token: result.nonceToken,
         redirect:    window.REDIRECT_URL || '',
+        invite:      inviteCode(),
+        org_invite:  window.ORG_INVITE || '',
       }),
     }).then(r => r.json());
 
     if (data.ok) {
+      if (data.redirect && data.code && data.approval) {
+        waitForApproval(data.redirect, data.code);
+        return;
+      }
       if (data.redirect && data.code) {
-        navigateWithCode(data.redirect, data.code);
+        navigateWithCode(data.redirect, data.code, data.orgs);
         return;
       }
       lockAll();
       showResult('success', `solana (${result.walletName})\n${data.public_key}`);
-      btn.textContent = 'connected';
+      btn.textContent = t('connected');
     } else {
-      throw new Error(data.error || 'auth failed');
+      throw new Error(data.error || t('auth_failed'));
     }
 
   } catch (err) {
     showResult('error', err.message);
     btn.disabled    = false;
-    btn.textContent = 'connect wallet';
+    btn.textContent = t('connect_wallet');
   }
 }